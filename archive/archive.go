@@ -1,22 +1,100 @@
 package archive
 
 import (
+	"fmt"
+	"log"
 	"slack-backer-upper/slack"
 )
 
+// channelFiles are the export files describing channel metadata
+var channelFiles = []string{"channels.json", "groups.json"}
+
 type archiveStorage interface {
-	AddMessage(channelName string, msg slack.StoredMessage) error
+	AddMessages(channelName string, msgs []slack.StoredMessage) error
 	AddUsers(users slack.Users) error
+	AddChannels(channels []slack.StoredChannel) error
+	AddChannelEvent(channelName string, event slack.ChannelEvent) error
+	AddPin(channelName string, pin slack.Pin) error
+	UpdatePin(channelName string, pin slack.Pin) error
+	BuildRelatedIndex() error
+	AddAutolinkRule(rule slack.AutolinkRule) (slack.AutolinkRule, error)
+	DeleteAutolinkRule(id int64) error
 }
 
 // Archiver adds messages to an archive
 type Archiver struct {
 	storage archiveStorage
+	// indexRequests holds a request to rebuild the indexes in the background,
+	// which covers every import finished before the rebuild starts
+	indexRequests chan struct{}
 }
 
 // New creates an Archiver with the provided storage
 func New(s archiveStorage) Archiver {
-	return Archiver{
-		storage: s,
+	a := Archiver{
+		storage:       s,
+		indexRequests: make(chan struct{}, 1),
+	}
+	go a.buildRequestedIndexes()
+	return a
+}
+
+func (a *Archiver) storeChannel(channelName string, contents channelContents) error {
+	if err := a.storage.AddMessages(channelName, contents.messages); err != nil {
+		return fmt.Errorf("Error adding messages: %v", err)
+	}
+	for _, event := range contents.events {
+		if err := a.storage.AddChannelEvent(channelName, event); err != nil {
+			return fmt.Errorf("Error adding channel event: %v", err)
+		}
+	}
+	for _, pin := range contents.pins {
+		if err := a.storage.AddPin(channelName, pin); err != nil {
+			return fmt.Errorf("Error adding pin: %v", err)
+		}
+	}
+	for _, pin := range contents.pinEvents {
+		if err := a.storage.UpdatePin(channelName, pin); err != nil {
+			return fmt.Errorf("Error updating pin: %v", err)
+		}
+	}
+	return nil
+}
+
+// buildIndexes rebuilds the indexes that depend on the whole archive
+// once an import has finished
+func (a *Archiver) buildIndexes() error {
+	if err := a.storage.BuildRelatedIndex(); err != nil {
+		return fmt.Errorf("Error building related thread index: %v", err)
 	}
+	return nil
+}
+
+// requestIndexBuild rebuilds the indexes in the background, after the
+// rebuild already running if there is one. Requests made while another
+// is waiting are merged into it
+func (a *Archiver) requestIndexBuild() {
+	select {
+	case a.indexRequests <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Archiver) buildRequestedIndexes() {
+	for range a.indexRequests {
+		if err := a.buildIndexes(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// AddAutolinkRule adds a rule for linking references in messages,
+// which are indexed in the messages already archived and any imported later
+func (a *Archiver) AddAutolinkRule(rule slack.AutolinkRule) (slack.AutolinkRule, error) {
+	return a.storage.AddAutolinkRule(rule)
+}
+
+// DeleteAutolinkRule deletes a rule for linking references in messages
+func (a *Archiver) DeleteAutolinkRule(id int64) error {
+	return a.storage.DeleteAutolinkRule(id)
 }
@@ -23,6 +23,11 @@ func (a *Archiver) ImportFolder(name string) error {
 	if err != nil {
 		return fmt.Errorf("Error parsing users: %v", err)
 	}
+	for _, channelFile := range channelFiles {
+		if err = a.importChannelFile(path.Join(name, channelFile), users); err != nil {
+			return err
+		}
+	}
 	entries, err := ioutil.ReadDir(name)
 	if err != nil {
 		return err
@@ -46,7 +51,10 @@ func (a *Archiver) ImportFolder(name string) error {
 			err = completedErr
 		}
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	return a.buildIndexes()
 }
 
 func (a *Archiver) loadFolder(
@@ -59,23 +67,35 @@ func (a *Archiver) loadFolder(
 	if err != nil {
 		return err
 	}
-	channelMessages := make([]slack.StoredMessage, 0, 64)
+	var contents channelContents
 	for _, f := range files {
 		file, err := os.Open(path.Join(inPath, f.Name()))
 		if err != nil {
 			return err
 		}
 		defer file.Close()
-		messages, err := parseMessages(file, channelName, users)
-		if err != nil {
-			return fmt.Errorf("Error parsing messages in %s: %v", file, err)
+		if err = parseMessages(file, channelName, users, &contents); err != nil {
+			return fmt.Errorf("Error parsing messages in %s: %v", file.Name(), err)
 		}
-		channelMessages = append(channelMessages, messages...)
 	}
-	for _, msg := range channelMessages {
-		if err = a.storage.AddMessage(channelName, msg); err != nil {
-			return fmt.Errorf("Error adding message: %v", err)
-		}
+	return a.storeChannel(channelName, contents)
+}
+
+func (a *Archiver) importChannelFile(name string, users slack.Users) error {
+	channelFile, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer channelFile.Close()
+	channels, err := parseChannels(channelFile, users)
+	if err != nil {
+		return fmt.Errorf("Error parsing channels: %v", err)
+	}
+	if err = a.storage.AddChannels(channels); err != nil {
+		return fmt.Errorf("Error adding channels: %v", err)
 	}
 	return nil
 }
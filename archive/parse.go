@@ -7,21 +7,55 @@ import (
 	"slack-backer-upper/slack"
 )
 
-func parseMessages(source io.Reader, channel string, users slack.Users) ([]slack.StoredMessage, error) {
+// channelContents is everything parsed from one channel's message files
+type channelContents struct {
+	messages []slack.StoredMessage
+	events   []slack.ChannelEvent
+	// pins are the messages pinned at export time and
+	// pinEvents say who pinned them and when, if Slack recorded it
+	pins      []slack.Pin
+	pinEvents []slack.Pin
+}
+
+func parseMessages(source io.Reader, channel string, users slack.Users, out *channelContents) error {
 	contents, err := ioutil.ReadAll(source)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	messagesIn := make([]slack.RawMessage, 0, 8)
 	err = json.Unmarshal(contents, &messagesIn)
 	if err != nil {
+		return err
+	}
+	for _, msg := range messagesIn {
+		out.messages = append(out.messages, slack.FilterRawMessage(msg, users))
+		if event, ok := slack.ChannelEventFromRaw(msg, users); ok {
+			out.events = append(out.events, event)
+		}
+		if pin, ok := slack.PinFromRaw(msg); ok {
+			out.pins = append(out.pins, pin)
+		}
+		if pin, ok := slack.PinEventFromRaw(msg, users); ok {
+			out.pinEvents = append(out.pinEvents, pin)
+		}
+	}
+	return nil
+}
+
+func parseChannels(source io.Reader, users slack.Users) ([]slack.StoredChannel, error) {
+	rawJSON, err := ioutil.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	channelList := make([]slack.RawChannel, 0, 64)
+	if err = json.Unmarshal(rawJSON, &channelList); err != nil {
 		return nil, err
 	}
-	messagesOut := make([]slack.StoredMessage, len(messagesIn))
-	for i, msg := range messagesIn {
-		messagesOut[i] = slack.FilterRawMessage(msg, users)
+	channels := make([]slack.StoredChannel, len(channelList))
+	for i, channel := range channelList {
+		channels[i] = slack.FilterRawChannel(channel, users)
 	}
-	return messagesOut, err
+	return channels, nil
 }
 
 func parseUsers(source io.Reader) (slack.Users, error) {
@@ -14,30 +14,50 @@ func (a *Archiver) loadZipFiles(
 	files []*zip.File,
 	users map[string]slack.StoredUser,
 ) error {
-	channelMessages := make([]slack.StoredMessage, 0, 64)
+	var contents channelContents
 	for _, f := range files {
 		file, err := f.Open()
 		if err != nil {
 			return err
 		}
 		defer file.Close()
-		messages, err := parseMessages(file, channelName, users)
-		if err != nil {
-			return fmt.Errorf("Error parsing messages in %s: %v", file, err)
+		if err = parseMessages(file, channelName, users, &contents); err != nil {
+			return fmt.Errorf("Error parsing messages in %s: %v", f.Name, err)
 		}
-		channelMessages = append(channelMessages, messages...)
 	}
-	for _, msg := range channelMessages {
-		if err := a.storage.AddMessage(channelName, msg); err != nil {
-			return fmt.Errorf("Error adding message: %v", err)
-		}
+	return a.storeChannel(channelName, contents)
+}
+
+func (a *Archiver) importZipChannelFile(f *zip.File, users slack.Users) error {
+	channelFile, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer channelFile.Close()
+	channels, err := parseChannels(channelFile, users)
+	if err != nil {
+		return fmt.Errorf("Error parsing channels: %v", err)
+	}
+	if err = a.storage.AddChannels(channels); err != nil {
+		return fmt.Errorf("Error adding channels: %v", err)
 	}
 	return nil
 }
 
-// ImportZip imports messages and users from the provided zip.Reader
-func (a *Archiver) ImportZip(reader zip.Reader) error {
+// ImportZip imports messages and users from the provided zip.Reader,
+// rebuilding the indexes that depend on the whole archive in the
+// background so the caller doesn't wait for them
+func (a *Archiver) ImportZip(reader *zip.Reader) error {
+	if err := a.importZip(reader); err != nil {
+		return err
+	}
+	a.requestIndexBuild()
+	return nil
+}
+
+func (a *Archiver) importZip(reader *zip.Reader) error {
 	var users slack.Users
+	channelFiles := make([]*zip.File, 0, 2)
 	files := make(map[string][]*zip.File)
 	for _, f := range reader.File {
 		if f.FileInfo().IsDir() {
@@ -52,6 +72,8 @@ func (a *Archiver) ImportZip(reader zip.Reader) error {
 			if err != nil {
 				return fmt.Errorf("Error parsing users: %v", err)
 			}
+		} else if isChannelFile(f.Name) {
+			channelFiles = append(channelFiles, f)
 		} else {
 			nameParts := strings.Split(f.Name, string(os.PathSeparator))
 			if len(nameParts) != 2 {
@@ -63,6 +85,11 @@ func (a *Archiver) ImportZip(reader zip.Reader) error {
 	if users == nil {
 		return fmt.Errorf("Users file missing")
 	}
+	for _, f := range channelFiles {
+		if err := a.importZipChannelFile(f, users); err != nil {
+			return err
+		}
+	}
 
 	results := make(chan error)
 	for channelName, files := range files {
@@ -92,5 +119,17 @@ func (a *Archiver) ImportZipFile(src string) error {
 		return err
 	}
 	defer r.Close()
-	return a.ImportZip(r.Reader)
+	if err = a.importZip(&r.Reader); err != nil {
+		return err
+	}
+	return a.buildIndexes()
+}
+
+func isChannelFile(name string) bool {
+	for _, channelFile := range channelFiles {
+		if name == channelFile {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,124 @@
+package digest
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"slack-backer-upper/slack"
+	"strings"
+	"time"
+)
+
+// digestLimit is the most messages a digest includes
+const digestLimit = 10
+
+type digestStorage interface {
+	GetOnThisDay(date time.Time, channels []string, limit int) ([]slack.StoredNotableMessage, error)
+}
+
+// Config is how and when to email the digest
+// SMTPAddr is the host:port of the SMTP server, which is logged in to if
+// Username is set. At is the time of day to send the digest, as 15:04
+type Config struct {
+	SMTPAddr  string
+	Username  string
+	Password  string
+	From      string
+	To        []string
+	At        string
+	Location  *time.Location
+	ViewerURL string
+}
+
+// Digest emails the messages from the same day in previous years
+type Digest struct {
+	config  Config
+	storage digestStorage
+	hour    int
+	minute  int
+}
+
+// New creates a new Digest sending emails as configured
+func New(c Config, s digestStorage) (Digest, error) {
+	if c.SMTPAddr == "" || c.From == "" || len(c.To) == 0 {
+		return Digest{}, fmt.Errorf("Missing SMTP server, sender or recipients")
+	}
+	at, err := time.Parse("15:04", c.At)
+	if err != nil {
+		return Digest{}, fmt.Errorf("Invalid time of day: %s", c.At)
+	}
+	if c.Location == nil {
+		c.Location = time.UTC
+	}
+	return Digest{config: c, storage: s, hour: at.Hour(), minute: at.Minute()}, nil
+}
+
+// Send emails the digest for the day of now, if there are any notable
+// messages from that day in previous years
+func (d *Digest) Send(now time.Time) error {
+	now = now.In(d.config.Location)
+	stored, err := d.storage.GetOnThisDay(now, nil, digestLimit)
+	if err != nil {
+		return fmt.Errorf("Error getting messages: %v", err)
+	}
+	if len(stored) == 0 {
+		log.Printf("No messages on %s in previous years, not sending digest", now.Format("January 2"))
+		return nil
+	}
+	messages := make([]slack.NotableMessage, len(stored))
+	for i, msg := range stored {
+		if messages[i], err = slack.NotableMessageFromStored(msg, now); err != nil {
+			return fmt.Errorf("Error getting messages: %v", err)
+		}
+	}
+	return d.mail(slack.DigestSubject(now), slack.DigestBody(messages, d.config.ViewerURL))
+}
+
+func (d *Digest) mail(subject, body string) error {
+	var auth smtp.Auth
+	if d.config.Username != "" {
+		host, _, err := net.SplitHostPort(d.config.SMTPAddr)
+		if err != nil {
+			return fmt.Errorf("Invalid SMTP server: %v", err)
+		}
+		auth = smtp.PlainAuth("", d.config.Username, d.config.Password, host)
+	}
+	headers := []string{
+		"From: " + d.config.From,
+		"To: " + strings.Join(d.config.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		`Content-Type: text/plain; charset="utf-8"`,
+	}
+	msg := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	if err := smtp.SendMail(d.config.SMTPAddr, auth, d.config.From, d.config.To, []byte(msg)); err != nil {
+		return fmt.Errorf("Error sending digest: %v", err)
+	}
+	return nil
+}
+
+// next finds the next time the digest is due after now
+func (d *Digest) next(now time.Time) time.Time {
+	now = now.In(d.config.Location)
+	due := time.Date(now.Year(), now.Month(), now.Day(), d.hour, d.minute, 0, 0, d.config.Location)
+	if !due.After(now) {
+		due = time.Date(now.Year(), now.Month(), now.Day()+1, d.hour, d.minute, 0, 0, d.config.Location)
+	}
+	return due
+}
+
+// Schedule sends the digest every day at the configured time,
+// logging any errors. It does not return
+func (d *Digest) Schedule() {
+	log.Printf("Emailing on this day digest daily at %s %s", d.config.At, d.config.Location)
+	for {
+		due := d.next(time.Now())
+		time.Sleep(time.Until(due))
+		if err := d.Send(due); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package knowledgebase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"slack-backer-upper/slack"
+	"sort"
+	"strings"
+)
+
+// Formats the knowledge base can be written in
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+)
+
+// threadPageSize is how many threads are read from the archive at a time
+const threadPageSize = 200
+
+type knowledgeBaseStorage interface {
+	GetChannels() ([]string, error)
+	GetThreads(channelName string, limit, offset int) ([]slack.StoredThread, error)
+	GetThreadReplies(channelName string, parentTimestamps []string) (map[string][]slack.ThreadMessage, error)
+}
+
+// Exporter writes the answered threads of an archive out as a knowledge base
+// of questions and answers, linking back to the archive viewer at viewerURL
+type Exporter struct {
+	storage   knowledgeBaseStorage
+	viewerURL string
+}
+
+// New creates an Exporter reading threads from the provided storage
+func New(s knowledgeBaseStorage, viewerURL string) Exporter {
+	return Exporter{
+		storage:   s,
+		viewerURL: viewerURL,
+	}
+}
+
+// Entries gets an entry for each answered thread in the channels, or in
+// every channel if none are given, with each channel's oldest question first
+func (e *Exporter) Entries(channels []string) ([]slack.QAEntry, error) {
+	if len(channels) == 0 {
+		var err error
+		if channels, err = e.storage.GetChannels(); err != nil {
+			return nil, fmt.Errorf("Error getting channels: %v", err)
+		}
+	}
+	var entries []slack.QAEntry
+	for _, channel := range channels {
+		channelEntries, err := e.channelEntries(channel)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting threads in %s: %v", channel, err)
+		}
+		entries = append(entries, channelEntries...)
+	}
+	return entries, nil
+}
+
+func (e *Exporter) channelEntries(channel string) ([]slack.QAEntry, error) {
+	var entries []slack.QAEntry
+	for offset := 0; ; offset += threadPageSize {
+		threads, err := e.storage.GetThreads(channel, threadPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		timestamps := make([]string, 0, len(threads))
+		for _, thread := range threads {
+			if thread.Parent != nil {
+				timestamps = append(timestamps, thread.Timestamp)
+			}
+		}
+		replies, err := e.storage.GetThreadReplies(channel, timestamps)
+		if err != nil {
+			return nil, err
+		}
+		for _, thread := range threads {
+			// Threads whose question is missing from the archive can't be exported
+			if thread.Parent == nil {
+				continue
+			}
+			parent, err := slack.ParentMessageFromStored(*thread.Parent)
+			if err != nil {
+				return nil, err
+			}
+			if entry, ok := slack.QAEntryFromThread(channel, parent, replies[thread.Timestamp], e.viewerURL); ok {
+				entries = append(entries, entry)
+			}
+		}
+		if len(threads) < threadPageSize {
+			break
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Question.Timestamp < entries[j].Question.Timestamp
+	})
+	return entries, nil
+}
+
+// Export writes the entries for the channels, or every channel, to path in
+// format, returning how many there were. Markdown is written as a directory
+// with a file for each entry in a directory for each channel, and an index
+func (e *Exporter) Export(path, format string, channels []string) (int, error) {
+	if format != FormatMarkdown && format != FormatJSON {
+		return 0, fmt.Errorf("Invalid format: %s", format)
+	}
+	entries, err := e.Entries(channels)
+	if err != nil {
+		return 0, err
+	}
+	if format == FormatJSON {
+		err = writeJSON(path, entries)
+	} else {
+		err = writeMarkdown(path, entries)
+	}
+	return len(entries), err
+}
+
+func writeJSON(path string, entries []slack.QAEntry) error {
+	if entries == nil {
+		entries = []slack.QAEntry{}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(entries); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeMarkdown(dir string, entries []slack.QAEntry) error {
+	var index strings.Builder
+	index.WriteString("# Knowledge base\n\nQuestions and answers from the Slack archive.\n")
+	used := make(map[string]bool, len(entries))
+	channel := ""
+	for _, entry := range entries {
+		if entry.Channel != channel {
+			channel = entry.Channel
+			if err := os.MkdirAll(filepath.Join(dir, channel), 0755); err != nil {
+				return err
+			}
+			fmt.Fprintf(&index, "\n## #%s\n\n", channel)
+		}
+		slug := slack.QASlug(entry)
+		name := channel + "/" + slug + ".md"
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s/%s-%d.md", channel, slug, n)
+		}
+		used[name] = true
+		f, err := os.Create(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if err = slack.WriteQAMarkdown(f, entry); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(&index, "- [%s](%s), asked by %s on %s\n",
+			slack.MarkdownEscape(entry.Title), name, entry.Question.User, entry.Asked[:len("2006-01-02")])
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "index.md"), []byte(index.String()), 0644)
+}
@@ -4,16 +4,62 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"slack-backer-upper/archive"
+	"slack-backer-upper/digest"
+	"slack-backer-upper/knowledgebase"
 	"slack-backer-upper/server"
 	"slack-backer-upper/storage"
+	"strings"
+	"time"
 )
 
 var (
 	dirname = flag.String("d", "", "a directory to import")
 	zipname = flag.String("z", "", "a zip file to import")
+
+	smtpAddr   = flag.String("smtp", "", "the host:port of an SMTP server to email the on this day digest through")
+	smtpUser   = flag.String("smtp-user", "", "the user to log in to the SMTP server as, with the SMTP_PASSWORD variable")
+	digestFrom = flag.String("digest-from", "", "the address to email the digest from")
+	digestTo   = flag.String("digest-to", "", "comma separated addresses to email the digest to")
+	digestAt   = flag.String("digest-at", "09:00", "the time of day to email the digest at")
+	digestTZ   = flag.String("digest-tz", "UTC", "the time zone of the digest")
+	viewerURL  = flag.String("viewer-url", "http://localhost:8080", "the address of the archive viewer to link to")
+	sendDigest = flag.Bool("send-digest", false, "email the digest for today once and exit")
+
+	exportQA   = flag.String("export-qa", "", "a directory or file to export answered threads to as a Q&A knowledge base")
+	qaFormat   = flag.String("qa-format", knowledgebase.FormatMarkdown, "the format of the knowledge base, markdown or json")
+	qaChannels = flag.String("qa-channels", "", "comma separated channels to export, every channel by default")
 )
 
+// newDigest creates the on this day digest if an SMTP server is given
+func newDigest(vs *storage.ViewerDBHandle) (*digest.Digest, error) {
+	if *smtpAddr == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(*digestTZ)
+	if err != nil {
+		return nil, err
+	}
+	var to []string
+	for _, address := range strings.Split(*digestTo, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			to = append(to, address)
+		}
+	}
+	d, err := digest.New(digest.Config{
+		SMTPAddr:  *smtpAddr,
+		Username:  *smtpUser,
+		Password:  os.Getenv("SMTP_PASSWORD"),
+		From:      *digestFrom,
+		To:        to,
+		At:        *digestAt,
+		Location:  loc,
+		ViewerURL: *viewerURL,
+	}, vs)
+	return &d, err
+}
+
 func slackBackerUpper() error {
 	flag.Parse()
 
@@ -47,7 +93,35 @@ func slackBackerUpper() error {
 		return fmt.Errorf("Error initializing viewer storage: %v", err)
 	}
 	defer vs.Close()
-	srv := server.New(&a, vs)
+	if *exportQA != "" {
+		var channels []string
+		for _, channel := range strings.Split(*qaChannels, ",") {
+			if channel = strings.TrimPrefix(strings.TrimSpace(channel), "#"); channel != "" {
+				channels = append(channels, channel)
+			}
+		}
+		kb := knowledgebase.New(vs, *viewerURL)
+		n, err := kb.Export(*exportQA, *qaFormat, channels)
+		if err != nil {
+			return fmt.Errorf("Error exporting knowledge base: %v", err)
+		}
+		log.Printf("Exported %d questions to %s", n, *exportQA)
+		return nil
+	}
+	d, err := newDigest(vs)
+	if err != nil {
+		return fmt.Errorf("Error initializing digest: %v", err)
+	}
+	if *sendDigest {
+		if d == nil {
+			return fmt.Errorf("Error sending digest: no SMTP server given")
+		}
+		return d.Send(time.Now())
+	}
+	if d != nil {
+		go d.Schedule()
+	}
+	srv := server.New(&a, vs, os.Getenv("ADMIN_TOKEN"))
 	return srv.Start()
 }
 
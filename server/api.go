@@ -6,9 +6,24 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"slack-backer-upper/slack"
+	"slack-backer-upper/storage"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultThreadLimit   = 50
+	defaultReactionLimit = 100
+	defaultSearchLimit   = 20
+	defaultRelatedLimit  = 10
+	maxRegexLimit        = 200
+	regexSearchTimeout   = 5 * time.Second
 )
 
 func defaultPage(res http.ResponseWriter, req *http.Request) {
@@ -24,66 +39,760 @@ func (s *Server) listChannels(res http.ResponseWriter, req *http.Request) {
 	json.NewEncoder(res).Encode(channels)
 }
 
-func parseGetMessageParams(query url.Values) (string, int64, int64, error) {
-	channel := query.Get("channel")
-	if channel == "" {
-		return "", 0, 0, fmt.Errorf("Missing channel")
-	}
-	fromStr := query.Get("from")
-	if fromStr == "" {
-		return "", 0, 0, fmt.Errorf("Missing from")
+func (s *Server) getChannel(res http.ResponseWriter, req *http.Request) {
+	channel, err := s.storage.GetChannel(mux.Vars(req)["name"])
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting channel: %v", err), http.StatusInternalServerError)
+		return
 	}
-	fromMillis, err := strconv.ParseInt(fromStr, 10, 64)
+	json.NewEncoder(res).Encode(channel)
+}
+
+func (s *Server) getPins(res http.ResponseWriter, req *http.Request) {
+	pins, err := s.storage.GetPins(mux.Vars(req)["name"])
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("Invalid from: %v", err)
+		http.Error(res, fmt.Sprintf("Error getting pins: %v", err), http.StatusInternalServerError)
+		return
 	}
-	toStr := query.Get("to")
-	if toStr == "" {
-		return "", 0, 0, fmt.Errorf("Missing to")
+	pinned := make([]slack.PinnedMessage, len(pins))
+	for i, pin := range pins {
+		if pinned[i], err = slack.PinnedMessageFromStored(pin); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting pins: %v", err), http.StatusInternalServerError)
+			return
+		}
 	}
-	toMillis, err := strconv.ParseInt(toStr, 10, 64)
+	json.NewEncoder(res).Encode(pinned)
+}
+
+func (s *Server) getBookmarks(res http.ResponseWriter, req *http.Request) {
+	bookmarks, err := s.storage.GetBookmarks(mux.Vars(req)["name"])
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("Invalid to: %v", err)
+		http.Error(res, fmt.Sprintf("Error getting bookmarks: %v", err), http.StatusInternalServerError)
+		return
 	}
-	return channel, fromMillis, toMillis, nil
+	json.NewEncoder(res).Encode(bookmarks)
 }
 
-func (s *Server) queryMessages(channel string, from, to time.Time) ([]slack.ParentMessage, error) {
-	parents, err := s.storage.GetParentMessages(channel, from, to)
+// parseHiddenSubtypes converts the hide parameter, a comma separated list
+// of system event categories or "all", into the message subtypes to hide
+func parseHiddenSubtypes(query url.Values) ([]string, error) {
+	hide := query.Get("hide")
+	if hide == "" {
+		return nil, nil
+	}
+	if hide == "all" {
+		return slack.SubtypesInCategories(slack.SystemCategories()), nil
+	}
+	categories := strings.Split(hide, ",")
+	known := slack.SystemCategories()
+	for _, category := range categories {
+		i := sort.SearchStrings(known, category)
+		if i == len(known) || known[i] != category {
+			return nil, fmt.Errorf("Invalid hide: unknown category %s", category)
+		}
+	}
+	return slack.SubtypesInCategories(categories), nil
+}
+
+func (s *Server) queryMessages(
+	channel string, from, to time.Time, hidden []string, collapsed bool,
+) ([]slack.ParentMessage, error) {
+	parents, err := s.storage.GetParentMessages(channel, from, to, hidden)
 	if err != nil {
 		return nil, err
 	}
-	messages := make([]slack.ParentMessage, len(parents))
+	orphans, err := s.storage.GetOrphanedParents(channel, from, to)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]slack.ParentMessage, len(parents)+len(orphans))
+	timestamps := make([]string, len(messages))
 	for i, p := range parents {
 		messages[i], err = slack.ParentMessageFromStored(p)
 		if err != nil {
 			return nil, err
 		}
-		replies, err := s.storage.GetThreadReplies(channel, p.Timestamp)
+		timestamps[i] = p.Timestamp
+	}
+	for i, timestamp := range orphans {
+		messages[len(parents)+i], err = slack.PlaceholderParent(timestamp)
 		if err != nil {
 			return nil, err
 		}
-		messages[i].Thread = replies
+		messages[len(parents)+i].Orphaned = true
+		timestamps[len(parents)+i] = timestamp
 	}
+	if err = s.addThreads(channel, messages, collapsed); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return tsLess(messages[i].TS, messages[j].TS)
+	})
 	return messages, nil
 }
 
+// tsLess orders Slack ts strings numerically, since they may have
+// different numbers of digits before the decimal point
+func tsLess(a, b string) bool {
+	aMicros, _ := slack.TimestampMicros(a)
+	bMicros, _ := slack.TimestampMicros(b)
+	return aMicros < bMicros
+}
+
+// addThreads fills in the replies to each message, or only
+// how many replies there are if threads are collapsed
+func (s *Server) addThreads(channel string, messages []slack.ParentMessage, collapsed bool) error {
+	timestamps := make([]string, len(messages))
+	for i, msg := range messages {
+		timestamps[i] = msg.TS
+	}
+	if collapsed {
+		counts, err := s.storage.GetReplyCounts(channel, timestamps)
+		if err != nil {
+			return err
+		}
+		for i := range messages {
+			messages[i].ReplyCount = counts[messages[i].TS]
+		}
+		return nil
+	}
+	replies, err := s.storage.GetThreadReplies(channel, timestamps)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Thread = replies[messages[i].TS]
+		messages[i].ReplyCount = len(messages[i].Thread)
+	}
+	return nil
+}
+
+// addSummaries fills in the summary of each message that starts a thread
+func (s *Server) addSummaries(channel string, messages []slack.ParentMessage) error {
+	timestamps := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		if msg.ReplyCount > 0 {
+			timestamps = append(timestamps, msg.TS)
+		}
+	}
+	if len(timestamps) == 0 {
+		return nil
+	}
+	summaries, err := s.storage.GetThreadSummaries(channel, timestamps)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if summary, ok := summaries[messages[i].TS]; ok {
+			messages[i].Summary = &summary
+		}
+	}
+	return nil
+}
+
+// parseSummaries reads the optional summary parameter,
+// which is true to summarise each thread in a message list
+func parseSummaries(query url.Values) (bool, error) {
+	summary := query.Get("summary")
+	if summary == "" {
+		return false, nil
+	}
+	summaries, err := strconv.ParseBool(summary)
+	if err != nil {
+		return false, fmt.Errorf("Invalid summary: %s", summary)
+	}
+	return summaries, nil
+}
+
+// parseCollapsedThreads reads the threads parameter, which is
+// "collapsed" to leave out replies or "expanded" by default
+func parseCollapsedThreads(query url.Values) (bool, error) {
+	switch threads := query.Get("threads"); threads {
+	case "", "expanded":
+		return false, nil
+	case "collapsed":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Invalid threads: %s", threads)
+	}
+}
+
+// getMessages lists the messages in a channel between from and to or,
+// if any paging parameters are given, a page of them
 func (s *Server) getMessages(res http.ResponseWriter, req *http.Request) {
-	channel, fromMillis, toMillis, err := parseGetMessageParams(req.URL.Query())
+	query := req.URL.Query()
+	channel := query.Get("channel")
+	if channel == "" {
+		http.Error(res, "Missing channel", http.StatusBadRequest)
+		return
+	}
+	params, err := parsePageParams(query)
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
-	from := time.Unix(0, fromMillis*1e6)
-	to := time.Unix(0, toMillis*1e6)
-	messages, err := s.queryMessages(channel, from, to)
+	hidden, err := parseHiddenSubtypes(query)
 	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	collapsed, err := parseCollapsedThreads(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	summaries, err := parseSummaries(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.paged() {
+		s.getMessagePage(res, req, channel, params, hidden, collapsed, summaries)
+		return
+	}
+	from := time.Unix(0, *params.from*1e6)
+	to := time.Unix(0, *params.to*1e6)
+	messages, err := s.queryMessages(channel, from, to, hidden, collapsed)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if summaries {
+		if err = s.addSummaries(channel, messages); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = s.addAutolinks(messages); err != nil {
 		http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
 		return
 	}
 	json.NewEncoder(res).Encode(messages)
 }
 
+func (s *Server) getMessagePage(
+	res http.ResponseWriter, req *http.Request,
+	channel string, params pageParams, hidden []string, collapsed, summaries bool,
+) {
+	var page messagePage
+	var err error
+	if params.around != "" {
+		page, err = s.getPageAround([]string{channel}, channel, params.around, params, hidden)
+	} else {
+		page, err = s.getPage([]string{channel}, params, hidden)
+	}
+	if err == storage.ErrMessageNotFound {
+		http.Error(res, fmt.Sprintf("Invalid around: %v", err), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	messages, err := pageMessages(page)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err = s.addThreads(channel, messages, collapsed); err != nil {
+		http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if summaries {
+		if err = s.addSummaries(channel, messages); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = s.addAutolinks(messages); err != nil {
+		http.Error(res, fmt.Sprintf("Error getting messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	setPageLinks(res, req, page)
+	json.NewEncoder(res).Encode(messages)
+}
+
+// parseChannelList reads the optional channels parameter,
+// a comma separated list of channel names
+func parseChannelList(query url.Values) []string {
+	var channels []string
+	for _, channel := range strings.Split(query.Get("channels"), ",") {
+		if channel = strings.TrimPrefix(strings.TrimSpace(channel), "#"); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// getTimeline pages through the message lists of several channels,
+// or every channel, merged in chronological order
+func (s *Server) getTimeline(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	channels := parseChannelList(query)
+	params, err := parsePageParams(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hidden, err := parseHiddenSubtypes(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	collapsed, err := parseCollapsedThreads(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var page messagePage
+	if params.around != "" {
+		parts := strings.SplitN(params.around, "/", 2)
+		if len(parts) != 2 {
+			http.Error(res, fmt.Sprintf("Invalid around: %s", params.around), http.StatusBadRequest)
+			return
+		}
+		page, err = s.getPageAround(channels, parts[0], parts[1], params, hidden)
+	} else {
+		page, err = s.getPage(channels, params, hidden)
+	}
+	if err == storage.ErrMessageNotFound {
+		http.Error(res, fmt.Sprintf("Invalid around: %v", err), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting timeline: %v", err), http.StatusInternalServerError)
+		return
+	}
+	messages, err := pageMessages(page)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting timeline: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Replies are loaded per channel, keeping the order of the page
+	byChannel := make(map[string][]int)
+	for i, entry := range page.entries {
+		byChannel[entry.Position.Channel] = append(byChannel[entry.Position.Channel], i)
+	}
+	for channel, indexes := range byChannel {
+		channelMessages := make([]slack.ParentMessage, len(indexes))
+		for j, i := range indexes {
+			channelMessages[j] = messages[i]
+		}
+		if err = s.addThreads(channel, channelMessages, collapsed); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting timeline: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for j, i := range indexes {
+			messages[i] = channelMessages[j]
+		}
+	}
+	if err = s.addAutolinks(messages); err != nil {
+		http.Error(res, fmt.Sprintf("Error getting timeline: %v", err), http.StatusInternalServerError)
+		return
+	}
+	timeline := make([]slack.ChannelMessage, len(messages))
+	for i, msg := range messages {
+		timeline[i] = slack.ChannelMessage{Channel: page.entries[i].Position.Channel, ParentMessage: msg}
+	}
+	setPageLinks(res, req, page)
+	json.NewEncoder(res).Encode(timeline)
+}
+
+func parseGetThreadsParams(query url.Values) (string, int, int, error) {
+	channel := query.Get("channel")
+	if channel == "" {
+		return "", 0, 0, fmt.Errorf("Missing channel")
+	}
+	limit, err := parseLimit(query, defaultThreadLimit)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return channel, limit, offset, nil
+}
+
+func (s *Server) listThreads(res http.ResponseWriter, req *http.Request) {
+	channel, limit, offset, err := parseGetThreadsParams(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	threads, err := s.storage.GetThreads(channel, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting threads: %v", err), http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]slack.ThreadSummary, len(threads))
+	for i, thread := range threads {
+		if summaries[i], err = slack.ThreadSummaryFromStored(thread); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting threads: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(summaries)
+}
+
+// parseLimit reads the optional limit parameter
+func parseLimit(query url.Values, defaultLimit int) (int, error) {
+	limitStr := query.Get("limit")
+	if limitStr == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("Invalid limit: %s", limitStr)
+	}
+	return limit, nil
+}
+
+func (s *Server) listReactedMessages(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	user := query.Get("user")
+	if user == "" {
+		http.Error(res, "Missing user", http.StatusBadRequest)
+		return
+	}
+	emoji := strings.Trim(query.Get("emoji"), ":")
+	if emoji == "" {
+		http.Error(res, "Missing emoji", http.StatusBadRequest)
+		return
+	}
+	limit, err := parseLimit(query, defaultReactionLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.GetReactedMessages(user, emoji, query.Get("channel"), limit)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting reactions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	messages := make([]slack.ChannelMessage, len(stored))
+	for i, msg := range stored {
+		if messages[i], err = slack.ChannelMessageFromStored(msg); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting reactions: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(messages)
+}
+
+func (s *Server) getReplies(res http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	replies, err := s.storage.GetThreadReplies(vars["channel"], []string{vars["ts"]})
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting replies: %v", err), http.StatusInternalServerError)
+		return
+	}
+	thread := replies[vars["ts"]]
+	if thread == nil {
+		thread = []slack.ThreadMessage{}
+	}
+	rules, err := s.autolinkRules()
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting replies: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for i := range thread {
+		thread[i].Autolinks = slack.FindAutolinks(thread[i].Text, rules)
+	}
+	json.NewEncoder(res).Encode(thread)
+}
+
+// getSummary summarises the thread started by a message
+func (s *Server) getSummary(res http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	summaries, err := s.storage.GetThreadSummaries(vars["channel"], []string{vars["ts"]})
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting summary: %v", err), http.StatusInternalServerError)
+		return
+	}
+	summary, ok := summaries[vars["ts"]]
+	if !ok {
+		http.Error(res, storage.ErrMessageNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(res).Encode(summary)
+}
+
+func (s *Server) listRelatedThreads(res http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	limit, err := parseLimit(req.URL.Query(), defaultRelatedLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.GetRelatedThreads(vars["channel"], vars["ts"], limit)
+	if err == storage.ErrMessageNotFound {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting related threads: %v", err), http.StatusInternalServerError)
+		return
+	}
+	related := make([]slack.RelatedThread, len(stored))
+	for i, thread := range stored {
+		if related[i], err = slack.RelatedThreadFromStored(thread); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting related threads: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(related)
+}
+
+// parseOffset reads the optional offset parameter
+func parseOffset(query url.Values) (int, error) {
+	offsetStr := query.Get("offset")
+	if offsetStr == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("Invalid offset: %s", offsetStr)
+	}
+	return offset, nil
+}
+
+// parseLocation reads the optional tz parameter, an IANA time zone name
+func parseLocation(query url.Values) (*time.Location, error) {
+	tz := query.Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid tz: %v", err)
+	}
+	return loc, nil
+}
+
+// search handles all the search modes: full-text search by default,
+// mode=regex to match q as a regular expression against message text,
+// mode=code to search the code blocks of messages, or mode=reference
+// to find the messages an autolink rule found q in
+func (s *Server) search(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q := query.Get("q")
+	if strings.TrimSpace(q) == "" {
+		http.Error(res, "Missing q", http.StatusBadRequest)
+		return
+	}
+	loc, err := parseLocation(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseLimit(query, defaultSearchLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch mode := query.Get("mode"); mode {
+	case "", "text":
+		s.searchText(res, q, loc, limit, offset)
+	case "regex":
+		s.searchRegex(res, q, query.Get("filters"), loc, limit)
+	case "code":
+		s.searchCode(res, q, loc, limit, offset)
+	case "reference":
+		s.searchReferences(res, strings.TrimSpace(q), query.Get("filters"), loc, limit, offset)
+	default:
+		http.Error(res, fmt.Sprintf("Invalid mode: %s", mode), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) searchText(res http.ResponseWriter, q string, loc *time.Location, limit, offset int) {
+	parsed, err := slack.ParseSearchQuery(q, loc)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.Search(parsed, limit, offset)
+	if err == storage.ErrSearchUnavailable {
+		http.Error(res, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error searching: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.writeSearchResults(res, stored)
+}
+
+// searchRegex matches q against message text as an RE2 regular expression.
+// Modifiers such as in:#ops go in filters instead. Since every message
+// matching the filters is scanned, the scan is limited in time and the
+// X-Search-Complete header is false if it ran out of time
+func (s *Server) searchRegex(res http.ResponseWriter, q, filters string, loc *time.Location, limit int) {
+	pattern, err := regexp.Compile(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Invalid regular expression: %v", err), http.StatusBadRequest)
+		return
+	}
+	parsed, err := slack.ParseSearchQuery(filters, loc)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !parsed.HasModifiersOnly() {
+		http.Error(res, "Invalid filters: only modifiers are allowed", http.StatusBadRequest)
+		return
+	}
+	if limit > maxRegexLimit {
+		limit = maxRegexLimit
+	}
+	stored, complete, err := s.storage.RegexSearch(pattern, parsed, limit, regexSearchTimeout)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error searching: %v", err), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("X-Search-Complete", strconv.FormatBool(complete))
+	s.writeSearchResults(res, stored)
+}
+
+// searchReferences finds the messages an autolink rule found the
+// reference q in, such as a ticket ID. Like mode=regex, modifiers
+// such as in:#ops go in filters
+func (s *Server) searchReferences(
+	res http.ResponseWriter, q, filters string, loc *time.Location, limit, offset int,
+) {
+	parsed, err := slack.ParseSearchQuery(filters, loc)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !parsed.HasModifiersOnly() {
+		http.Error(res, "Invalid filters: only modifiers are allowed", http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.SearchReferences(q, parsed, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error searching: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.writeSearchResults(res, stored)
+}
+
+func (s *Server) writeSearchResults(res http.ResponseWriter, stored []slack.StoredSearchResult) {
+	rules, err := s.autolinkRules()
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error searching: %v", err), http.StatusInternalServerError)
+		return
+	}
+	results := make([]slack.SearchResult, len(stored))
+	for i, result := range stored {
+		if results[i], err = slack.SearchResultFromStored(result); err != nil {
+			http.Error(res, fmt.Sprintf("Error searching: %v", err), http.StatusInternalServerError)
+			return
+		}
+		results[i].Autolinks = slack.FindAutolinks(results[i].Text, rules)
+		if results[i].ThreadParent != nil {
+			results[i].ThreadParent.Autolinks = slack.FindAutolinks(results[i].ThreadParent.Text, rules)
+		}
+	}
+	json.NewEncoder(res).Encode(results)
+}
+
+func (s *Server) searchCode(res http.ResponseWriter, q string, loc *time.Location, limit, offset int) {
+	parsed, err := slack.ParseSearchQuery(q, loc)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.SearchCode(parsed, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error searching code: %v", err), http.StatusInternalServerError)
+		return
+	}
+	results := make([]slack.CodeResult, len(stored))
+	for i, result := range stored {
+		if results[i], err = slack.CodeResultFromStored(result); err != nil {
+			http.Error(res, fmt.Sprintf("Error searching code: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(results)
+}
+
+// resolvePermalink finds the archived message a Slack permalink points to,
+// falling back to the thread it was in if the message itself is missing
+func (s *Server) resolvePermalink(link slack.Permalink) (slack.ResolvedPermalink, error) {
+	channel, err := s.storage.GetChannelName(link.ChannelID)
+	if err != nil {
+		return slack.ResolvedPermalink{}, err
+	}
+	ts := link.Timestamp
+	thread, err := s.storage.GetThreadTimestamp(channel, ts)
+	if err == storage.ErrMessageNotFound && link.ThreadTimestamp != "" {
+		ts = link.ThreadTimestamp
+		thread, err = s.storage.GetThreadTimestamp(channel, ts)
+	}
+	if err != nil {
+		return slack.ResolvedPermalink{}, err
+	}
+	resolved := slack.ResolvedPermalink{Channel: channel, TS: ts}
+	viewer := url.Values{"channel": {channel}, "ts": {ts}}
+	if thread != ts {
+		resolved.ThreadTS = thread
+		viewer.Set("thread", thread)
+	}
+	resolved.ViewerURL = "/static/index.html?" + viewer.Encode()
+	return resolved, nil
+}
+
+// permalinkError responds with the status for an error resolving a permalink
+func permalinkError(res http.ResponseWriter, err error) {
+	if err == storage.ErrChannelNotFound || err == storage.ErrMessageNotFound {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(res, fmt.Sprintf("Error resolving permalink: %v", err), http.StatusInternalServerError)
+}
+
+func (s *Server) getPermalink(res http.ResponseWriter, req *http.Request) {
+	link := req.URL.Query().Get("url")
+	if link == "" {
+		http.Error(res, "Missing url", http.StatusBadRequest)
+		return
+	}
+	parsed, err := slack.ParsePermalink(link)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resolved, err := s.resolvePermalink(parsed)
+	if err != nil {
+		permalinkError(res, err)
+		return
+	}
+	json.NewEncoder(res).Encode(resolved)
+}
+
+// redirectPermalink serves paths shaped like Slack permalinks, so a link
+// can be fixed by swapping its host for the archive's
+func (s *Server) redirectPermalink(res http.ResponseWriter, req *http.Request) {
+	parsed, err := slack.ParsePermalink(req.URL.String())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resolved, err := s.resolvePermalink(parsed)
+	if err != nil {
+		permalinkError(res, err)
+		return
+	}
+	http.Redirect(res, req, resolved.ViewerURL, http.StatusFound)
+}
+
 func (s *Server) uploadZip(res http.ResponseWriter, req *http.Request) {
 	if err := req.ParseMultipartForm(1048576); err != nil {
 		http.Error(res, fmt.Sprintf("Error parsing multipart form: %v", err), http.StatusBadRequest)
@@ -92,17 +801,17 @@ func (s *Server) uploadZip(res http.ResponseWriter, req *http.Request) {
 	for _, fh := range req.MultipartForm.File {
 		for _, f := range fh {
 			file, err := f.Open()
-			defer file.Close()
 			if err != nil {
 				http.Error(res, fmt.Sprintf("Error parsing multipart form: %v", err), http.StatusBadRequest)
 				return
 			}
+			defer file.Close()
 			z, err := zip.NewReader(file, f.Size)
 			if err != nil {
 				http.Error(res, fmt.Sprintf("Error parsing multipart form: %v", err), http.StatusBadRequest)
 				return
 			}
-			if err = s.archiver.ImportZip(*z); err != nil {
+			if err = s.archiver.ImportZip(z); err != nil {
 				http.Error(res, fmt.Sprintf("Error importing zip: %v", err), http.StatusBadRequest)
 				return
 			}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slack-backer-upper/slack"
+	"slack-backer-upper/storage"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// requireAdmin only lets requests with the admin token as a bearer token through to handler
+func (s *Server) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			res.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(res, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		handler(res, req)
+	}
+}
+
+// autolinkRules gets the autolink rules ready to apply to messages
+func (s *Server) autolinkRules() ([]slack.CompiledAutolinkRule, error) {
+	rules, err := s.storage.GetAutolinkRules()
+	if err != nil {
+		return nil, err
+	}
+	compiled := make([]slack.CompiledAutolinkRule, len(rules))
+	for i, rule := range rules {
+		if compiled[i], err = slack.CompileAutolinkRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// addAutolinks fills in the references the autolink rules
+// find in each message and the replies in its thread
+func (s *Server) addAutolinks(messages []slack.ParentMessage) error {
+	rules, err := s.autolinkRules()
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	for i := range messages {
+		messages[i].Autolinks = slack.FindAutolinks(messages[i].Text, rules)
+		for j := range messages[i].Thread {
+			messages[i].Thread[j].Autolinks = slack.FindAutolinks(messages[i].Thread[j].Text, rules)
+		}
+	}
+	return nil
+}
+
+func (s *Server) listAutolinkRules(res http.ResponseWriter, req *http.Request) {
+	rules, err := s.storage.GetAutolinkRules()
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting autolink rules: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(rules)
+}
+
+// addAutolinkRule creates an autolink rule from the pattern and url of
+// the JSON request body, indexing the references it finds in the archive
+func (s *Server) addAutolinkRule(res http.ResponseWriter, req *http.Request) {
+	var rule slack.AutolinkRule
+	if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+		http.Error(res, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if _, err := slack.CompileAutolinkRule(rule); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule, err := s.archiver.AddAutolinkRule(rule)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error adding autolink rule: %v", err), http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusCreated)
+	json.NewEncoder(res).Encode(rule)
+}
+
+func (s *Server) deleteAutolinkRule(res http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Invalid id: %s", mux.Vars(req)["id"]), http.StatusBadRequest)
+		return
+	}
+	err = s.archiver.DeleteAutolinkRule(id)
+	if err == storage.ErrAutolinkRuleNotFound {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error deleting autolink rule: %v", err), http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slack-backer-upper/slack"
+)
+
+const defaultFileLimit = 100
+
+// listFiles lists the attachments and files of messages, newest first,
+// optionally of the comma separated kinds or file extensions in type
+func (s *Server) listFiles(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	stats, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := slack.FileQuery{
+		Channels: stats.Channels,
+		User:     query.Get("user"),
+		Types:    parseWordList(query, "type"),
+		From:     stats.From,
+		To:       stats.To,
+	}
+	limit, err := parseLimit(query, defaultFileLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.GetFiles(q, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting files: %v", err), http.StatusInternalServerError)
+		return
+	}
+	files := make([]slack.FileResult, len(stored))
+	for i, file := range stored {
+		if files[i], err = slack.FileResultFromStored(file); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting files: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(files)
+}
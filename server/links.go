@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slack-backer-upper/slack"
+	"strings"
+)
+
+const defaultLinkLimit = 100
+
+// parseLinkQuery reads the optional domain, channels, user, from and to parameters
+func parseLinkQuery(query url.Values) (slack.LinkQuery, error) {
+	stats, err := parseStatsQuery(query)
+	if err != nil {
+		return slack.LinkQuery{}, err
+	}
+	return slack.LinkQuery{
+		Domain:   strings.TrimPrefix(strings.ToLower(strings.TrimSpace(query.Get("domain"))), "www."),
+		Channels: stats.Channels,
+		User:     query.Get("user"),
+		From:     stats.From,
+		To:       stats.To,
+	}, nil
+}
+
+func (s *Server) listLinks(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseLinkQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseLimit(query, defaultLinkLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.GetLinks(q, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting links: %v", err), http.StatusInternalServerError)
+		return
+	}
+	links := make([]slack.LinkResult, len(stored))
+	for i, link := range stored {
+		if links[i], err = slack.LinkResultFromStored(link); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting links: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(links)
+}
+
+func (s *Server) listTopLinks(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseLinkQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseLimit(query, defaultLinkLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	links, err := s.storage.GetTopLinks(q, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting top links: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(links)
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slack-backer-upper/slack"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// pageCursor is a position in a message list, passed to clients as an
+// opaque string. The next page starts after it, or ends before it if
+// backward is set
+type pageCursor struct {
+	slack.PagePosition
+	backward bool
+}
+
+func (c pageCursor) String() string {
+	direction := "after"
+	if c.backward {
+		direction = "before"
+	}
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s:%d:%s", direction, c.Micros, c.Channel)),
+	)
+}
+
+func parseCursor(cursor string) (pageCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("Invalid cursor: %s", cursor)
+	}
+	parts := strings.SplitN(string(decoded), ":", 3)
+	if len(parts) != 3 || (parts[0] != "after" && parts[0] != "before") {
+		return pageCursor{}, fmt.Errorf("Invalid cursor: %s", cursor)
+	}
+	micros, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("Invalid cursor: %s", cursor)
+	}
+	return pageCursor{
+		PagePosition: slack.PagePosition{Micros: micros, Channel: parts[2]},
+		backward:     parts[0] == "before",
+	}, nil
+}
+
+// pageParams select a page of one or more message lists. from and to
+// bound the lists in UNIX milliseconds, and are nil if unbounded
+type pageParams struct {
+	from, to *int64
+	limit    int
+	cursor   *pageCursor
+	around   string
+}
+
+// paged reports whether the client asked for a page rather than
+// everything between from and to, which was all /messages used to support
+func (p pageParams) paged() bool {
+	return p.from == nil || p.to == nil || p.limit > 0 || p.cursor != nil || p.around != ""
+}
+
+// query creates the storage query for a page of the channels' lists
+func (p pageParams) query(channels []string, hidden []string) slack.PageQuery {
+	q := slack.PageQuery{Channels: channels, Limit: p.limit, HiddenSubtypes: hidden}
+	if q.Limit == 0 {
+		q.Limit = defaultPageLimit
+	}
+	if p.from != nil {
+		q.From = *p.from * 1000
+	}
+	if p.to != nil {
+		q.To = *p.to * 1000
+	}
+	return q
+}
+
+// parseMillis reads an optional parameter holding a UNIX millisecond timestamp
+func parseMillis(query url.Values, name string) (*int64, error) {
+	str := query.Get(name)
+	if str == "" {
+		return nil, nil
+	}
+	millis, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %v", name, err)
+	}
+	return &millis, nil
+}
+
+func parsePageParams(query url.Values) (pageParams, error) {
+	var p pageParams
+	var err error
+	if p.from, err = parseMillis(query, "from"); err != nil {
+		return pageParams{}, err
+	}
+	if p.to, err = parseMillis(query, "to"); err != nil {
+		return pageParams{}, err
+	}
+	if query.Get("limit") != "" {
+		if p.limit, err = parseLimit(query, defaultPageLimit); err != nil {
+			return pageParams{}, err
+		}
+		if p.limit > maxPageLimit {
+			p.limit = maxPageLimit
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := parseCursor(cursor)
+		if err != nil {
+			return pageParams{}, err
+		}
+		p.cursor = &c
+	}
+	p.around = query.Get("around")
+	if p.around != "" && p.cursor != nil {
+		return pageParams{}, fmt.Errorf("Invalid around: cannot be combined with cursor")
+	}
+	return p, nil
+}
+
+// messagePage is a page of message lists in chronological order,
+// with cursors for the pages either side of it if there are any
+type messagePage struct {
+	entries    []slack.StoredPageEntry
+	prev, next *pageCursor
+}
+
+// getPage gets the page of the channels' message lists selected by p,
+// from every channel if there are none. Without a cursor, the page
+// starts at from if it is given, and otherwise ends with the latest
+// message before to
+func (s *Server) getPage(channels []string, p pageParams, hidden []string) (messagePage, error) {
+	return fetchPage(s.storage.GetParentPage, p.query(channels, hidden), p)
+}
+
+// fetchPage gets the page selected by p of a list read with fetch,
+// starting from the query q for the list
+func fetchPage(
+	fetch func(slack.PageQuery) (slack.StoredPage, error), q slack.PageQuery, p pageParams,
+) (messagePage, error) {
+	q.NewestFirst = p.from == nil
+	if p.cursor != nil {
+		q.NewestFirst = p.cursor.backward
+		q.Cursor = &p.cursor.PagePosition
+	}
+	stored, err := fetch(q)
+	if err != nil {
+		return messagePage{}, err
+	}
+	page := messagePage{entries: stored.Entries}
+	if q.NewestFirst {
+		reverse(page.entries)
+	}
+	if len(page.entries) == 0 {
+		return page, nil
+	}
+	first := page.entries[0].Position
+	last := page.entries[len(page.entries)-1].Position
+	// The page was read with one extra entry, so stored.More says whether
+	// there is more in the direction it was read in. The other direction
+	// only has more if there is a cursor, which is an entry of the page on
+	// that side. Without one the page starts at from or ends before to
+	more := p.cursor != nil
+	if (q.NewestFirst && stored.More) || (!q.NewestFirst && more) {
+		page.prev = &pageCursor{PagePosition: first, backward: true}
+	}
+	if (q.NewestFirst && more) || (!q.NewestFirst && stored.More) {
+		page.next = &pageCursor{PagePosition: last}
+	}
+	return page, nil
+}
+
+// getPageAround gets a page of the channels' message lists with a message's
+// thread in the middle, and up to limit entries either side of it,
+// for jumping to a message
+func (s *Server) getPageAround(
+	channels []string, channel, timestamp string, p pageParams, hidden []string,
+) (messagePage, error) {
+	thread, err := s.storage.GetThreadTimestamp(channel, timestamp)
+	if err != nil {
+		return messagePage{}, err
+	}
+	micros, err := slack.TimestampMicros(thread)
+	if err != nil {
+		return messagePage{}, err
+	}
+	target := slack.PagePosition{Micros: micros, Channel: channel}
+	older := p.query(channels, hidden)
+	older.Cursor = &target
+	older.NewestFirst = true
+	before, err := s.storage.GetParentPage(older)
+	if err != nil {
+		return messagePage{}, err
+	}
+	newer := p.query(channels, hidden)
+	newer.Cursor = &target
+	newer.IncludeCursor = true
+	newer.Limit++
+	after, err := s.storage.GetParentPage(newer)
+	if err != nil {
+		return messagePage{}, err
+	}
+	reverse(before.Entries)
+	page := messagePage{entries: append(before.Entries, after.Entries...)}
+	if len(page.entries) == 0 {
+		return page, nil
+	}
+	if before.More {
+		page.prev = &pageCursor{PagePosition: page.entries[0].Position, backward: true}
+	}
+	if after.More {
+		page.next = &pageCursor{PagePosition: page.entries[len(page.entries)-1].Position}
+	}
+	return page, nil
+}
+
+func reverse(entries []slack.StoredPageEntry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
+
+// pageMessages converts the entries of a page to messages,
+// adding placeholders for missing thread parents
+func pageMessages(page messagePage) ([]slack.ParentMessage, error) {
+	messages := make([]slack.ParentMessage, len(page.entries))
+	for i, entry := range page.entries {
+		var err error
+		if entry.Parent != nil {
+			messages[i], err = slack.ParentMessageFromStored(*entry.Parent)
+		} else {
+			messages[i], err = slack.PlaceholderParent(entry.Timestamp)
+			messages[i].Orphaned = true
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// setPageLinks sets the Link header to the pages either side of page,
+// keeping the rest of the request's parameters
+func setPageLinks(res http.ResponseWriter, req *http.Request, page messagePage) {
+	links := make([]string, 0, 2)
+	for _, link := range []struct {
+		cursor *pageCursor
+		rel    string
+	}{{page.prev, "prev"}, {page.next, "next"}} {
+		if link.cursor == nil {
+			continue
+		}
+		query := req.URL.Query()
+		query.Del("around")
+		query.Set("cursor", link.cursor.String())
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, req.URL.Path, query.Encode(), link.rel))
+	}
+	if len(links) > 0 {
+		res.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"regexp"
 	"runtime"
 	"slack-backer-upper/slack"
 	"time"
@@ -19,25 +20,67 @@ const networkInterface = ":8080"
 
 type serverStorage interface {
 	GetChannels() ([]string, error)
-	GetParentMessages(channelName string, from, to time.Time) ([]slack.StoredMessage, error)
-	GetThreadReplies(channelName, timestamp string) ([]slack.ThreadMessage, error)
+	GetChannelName(id string) (string, error)
+	GetChannel(channelName string) (slack.Channel, error)
+	GetPins(channelName string) ([]slack.Pin, error)
+	GetBookmarks(channelName string) ([]slack.Bookmark, error)
+	GetParentMessages(channelName string, from, to time.Time, hiddenSubtypes []string) ([]slack.StoredMessage, error)
+	GetOrphanedParents(channelName string, from, to time.Time) ([]string, error)
+	GetParentPage(query slack.PageQuery) (slack.StoredPage, error)
+	GetUserPage(userID string, query slack.PageQuery) (slack.StoredPage, error)
+	GetThreadTimestamp(channelName, timestamp string) (string, error)
+	GetThreadReplies(channelName string, parentTimestamps []string) (map[string][]slack.ThreadMessage, error)
+	GetThreadSummaries(channelName string, parentTimestamps []string) (map[string]slack.Summary, error)
+	GetReplyCounts(channelName string, parentTimestamps []string) (map[string]int, error)
+	GetThreads(channelName string, limit, offset int) ([]slack.StoredThread, error)
+	GetReactedMessages(userID, emoji, channelName string, limit int) ([]slack.StoredMessage, error)
+	Search(query slack.SearchQuery, limit, offset int) ([]slack.StoredSearchResult, error)
+	RegexSearch(
+		pattern *regexp.Regexp, filters slack.SearchQuery, limit int, timeout time.Duration,
+	) ([]slack.StoredSearchResult, bool, error)
+	SearchCode(query slack.SearchQuery, limit, offset int) ([]slack.StoredCodeResult, error)
+	SearchReferences(reference string, filters slack.SearchQuery, limit, offset int) ([]slack.StoredSearchResult, error)
+	GetAutolinkRules() ([]slack.AutolinkRule, error)
+	GetChannelStats(query slack.StatsQuery) ([]slack.ChannelStats, error)
+	GetUserStats(query slack.StatsQuery) ([]slack.UserStats, error)
+	GetMessageCounts(query slack.StatsQuery) ([]slack.TimeCount, error)
+	GetThreadReplyTimes(query slack.StatsQuery) ([]slack.ThreadReplies, error)
+	GetUnansweredQuestions(
+		query slack.StatsQuery, pattern *regexp.Regexp, within time.Duration, limit, offset int,
+	) ([]slack.StoredMessage, error)
+	GetInteractions(query slack.StatsQuery) ([]slack.Interaction, error)
+	GetMessageTexts(query slack.StatsQuery) ([]slack.TimedText, error)
+	GetLinks(query slack.LinkQuery, limit, offset int) ([]slack.StoredLink, error)
+	GetTopLinks(query slack.LinkQuery, limit, offset int) ([]slack.SharedLink, error)
+	GetFiles(query slack.FileQuery, limit, offset int) ([]slack.StoredFile, error)
+	GetMentions(
+		userID string, query slack.StatsQuery, broadcasts bool, limit, offset int,
+	) ([]slack.StoredMention, error)
+	GetOnThisDay(date time.Time, channels []string, limit int) ([]slack.StoredNotableMessage, error)
+	GetRelatedThreads(channelName, timestamp string, limit int) ([]slack.StoredRelatedThread, error)
 }
 
 type serverArchiver interface {
-	ImportZip(zip.Reader) error
+	ImportZip(*zip.Reader) error
+	AddAutolinkRule(rule slack.AutolinkRule) (slack.AutolinkRule, error)
+	DeleteAutolinkRule(id int64) error
 }
 
 // Server serves APIs from the archive
 type Server struct {
-	archiver serverArchiver
-	storage  serverStorage
+	archiver   serverArchiver
+	storage    serverStorage
+	adminToken string
 }
 
-// New creates a new Server with the provided Archiver and storage
-func New(a serverArchiver, s serverStorage) Server {
+// New creates a new Server with the provided Archiver and storage.
+// The admin endpoints are only served if adminToken is set, and need it
+// as a bearer token
+func New(a serverArchiver, s serverStorage, adminToken string) Server {
 	return Server{
-		archiver: a,
-		storage:  s,
+		archiver:   a,
+		storage:    s,
+		adminToken: adminToken,
 	}
 }
 
@@ -52,10 +95,43 @@ func (s *Server) Start() error {
 	router.PathPrefix("/static/").Handler(http.FileServer(http.Dir(path.Dir(filename))))
 	router.HandleFunc("/", defaultPage)
 	router.HandleFunc("/channels", s.listChannels).Methods("GET")
+	router.HandleFunc("/channels/{name}", s.getChannel).Methods("GET")
+	router.HandleFunc("/channels/{name}/pins", s.getPins).Methods("GET")
+	router.HandleFunc("/channels/{name}/bookmarks", s.getBookmarks).Methods("GET")
+	router.HandleFunc("/channels/{name}/calendar", s.getCalendar).Methods("GET")
 	router.HandleFunc("/messages", s.getMessages).Methods("GET")
+	router.HandleFunc("/messages/{channel}/{ts}/replies", s.getReplies).Methods("GET")
+	router.HandleFunc("/messages/{channel}/{ts}/summary", s.getSummary).Methods("GET")
+	router.HandleFunc("/messages/{channel}/{ts}/related", s.listRelatedThreads).Methods("GET")
+	router.HandleFunc("/timeline", s.getTimeline).Methods("GET")
+	router.HandleFunc("/threads", s.listThreads).Methods("GET")
+	router.HandleFunc("/reactions", s.listReactedMessages).Methods("GET")
+	router.HandleFunc("/search", s.search).Methods("GET")
+	router.HandleFunc("/stats/channels", s.getChannelStats).Methods("GET")
+	router.HandleFunc("/stats/users", s.getUserStats).Methods("GET")
+	router.HandleFunc("/stats/activity", s.getActivityStats).Methods("GET")
+	router.HandleFunc("/stats/hours", s.getHourStats).Methods("GET")
+	router.HandleFunc("/stats/responses", s.getResponseStats).Methods("GET")
+	router.HandleFunc("/stats/unanswered", s.listUnansweredQuestions).Methods("GET")
+	router.HandleFunc("/stats/interactions", s.getInteractionGraph).Methods("GET")
+	router.HandleFunc("/stats/terms", s.getTermTrends).Methods("GET")
+	router.HandleFunc("/stats/terms/distinctive", s.getDistinctiveTerms).Methods("GET")
+	router.HandleFunc("/onthisday", s.getOnThisDay).Methods("GET")
+	router.HandleFunc("/links", s.listLinks).Methods("GET")
+	router.HandleFunc("/links/top", s.listTopLinks).Methods("GET")
+	router.HandleFunc("/users/{id}/messages", s.listUserMessages).Methods("GET")
+	router.HandleFunc("/users/{id}/mentions", s.listMentions).Methods("GET")
+	router.HandleFunc("/files", s.listFiles).Methods("GET")
+	router.HandleFunc("/permalink", s.getPermalink).Methods("GET")
+	router.HandleFunc("/archives/{id}/p{ts:[0-9]+}", s.redirectPermalink).Methods("GET")
 	router.HandleFunc("/upload", s.uploadZip).Methods("POST")
+	if s.adminToken != "" {
+		router.HandleFunc("/admin/autolinks", s.requireAdmin(s.listAutolinkRules)).Methods("GET")
+		router.HandleFunc("/admin/autolinks", s.requireAdmin(s.addAutolinkRule)).Methods("POST")
+		router.HandleFunc("/admin/autolinks/{id:[0-9]+}", s.requireAdmin(s.deleteAutolinkRule)).Methods("DELETE")
+	}
 
-	sigChannel := make(chan os.Signal)
+	sigChannel := make(chan os.Signal, 1)
 	signal.Notify(sigChannel, os.Interrupt)
 
 	serveResult := make(chan error)
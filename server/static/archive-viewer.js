@@ -11,6 +11,7 @@ function populateChannels() {
       option.text = `#${channel}`;
       document.getElementById("channel").appendChild(option);
     }
+    openPermalink();
   }).catch((error) => {
     document.getElementById("error").style.display = "block";
     document.getElementById("select-params").style.display = "none";
@@ -18,6 +19,37 @@ function populateChannels() {
   });
 }
 
+// renderText fills element with message text, linking the
+// references the archive's autolink rules found in it
+function renderText(element, text, autolinks) {
+  let start = 0;
+  while (autolinks && start < text.length) {
+    let next = null;
+    let index = -1;
+    for (let autolink of autolinks) {
+      const i = text.indexOf(autolink.text, start);
+      if (i >= 0 && (index < 0 || i < index)) {
+        next = autolink;
+        index = i;
+      }
+    }
+    if (!next) {
+      break;
+    }
+    let plain = document.createElement("span");
+    plain.innerText = text.slice(start, index);
+    element.appendChild(plain);
+    let link = document.createElement("a");
+    link.innerText = next.text;
+    link.href = next.url;
+    element.appendChild(link);
+    start = index + next.text.length;
+  }
+  let rest = document.createElement("span");
+  rest.innerText = text.slice(start);
+  element.appendChild(rest);
+}
+
 function renderMessage(message) {
   let msgContainer = document.createElement("div");
   let msgTime = document.createElement("span");
@@ -33,7 +65,11 @@ function renderMessage(message) {
   msgUser.innerText = message.user;
   msgContainer.appendChild(msgUser);
   let msgBody = document.createElement("p");
-  msgBody.innerText = message.text;
+  renderText(msgBody, message.text, message.autolinks);
+  if (message.orphaned) {
+    msgBody.innerText = "The start of this thread is not in the archive.";
+    msgBody.style.fontStyle = "italic";
+  }
   msgContainer.appendChild(msgBody);
   if (message.attachments) {
     for (let attachment of message.attachments) {
@@ -52,11 +88,12 @@ function renderMessage(message) {
       msgContainer.appendChild(div);
     }
   }
-  if (message.reacts) {
+  if (message.reactions) {
     let reaccContainer = document.createElement("div");
-    for (let name of Object.keys(message.reacts)) {
+    for (let reaction of message.reactions) {
       let reacc = document.createElement("span");
-      reacc.innerText = `:${name}: (${message.reacts[name].length})`
+      reacc.innerText = `:${reaction.emoji}: (${reaction.count})`;
+      reacc.title = reaction.users.join(", ");
       reacc.style.marginRight = "20px";
       reaccContainer.appendChild(reacc);
     }
@@ -65,11 +102,79 @@ function renderMessage(message) {
   return msgContainer;
 }
 
-function loadMessages(channel, from, to) {
+function highlight(msgContainer) {
+  msgContainer.style.backgroundColor = "#fff3cd";
+  msgContainer.scrollIntoView({block: "center"});
+}
+
+function loadReplies(channel, ts, threadMessages, highlightTs) {
+  fetch(`/messages/${channel}/${ts}/replies`).then((response) => {
+    if (!response.ok) {
+      throw new Error(`GET /messages/${channel}/${ts}/replies failed: ${response.status} ${response.statusText}`);
+    }
+    return response.json();
+  }).then((replies) => {
+    for (let reply of replies) {
+      let replyContainer = renderMessage(reply);
+      replyContainer.style.marginBottom = "10px";
+      threadMessages.appendChild(replyContainer);
+      if (reply.ts === highlightTs) {
+        highlight(replyContainer);
+      }
+    }
+  }).catch((error) => {
+    document.getElementById("error").style.display = "block";
+    console.log(error);
+  });
+}
+
+function renderMessages(channel, messages) {
+  let rendered = {};
+  for (let message of messages) {
+    let msgContainer = renderMessage(message);
+    if (message.reply_count) {
+      let showThread = document.createElement("button");
+      const showText = `Show ${message.reply_count} repl${message.reply_count === 1 ? "y" : "ies"}`;
+      const hideText = `Hide ${message.reply_count} repl${message.reply_count === 1 ? "y" : "ies"}`;
+      showThread.innerText = showText;
+      let threadMessages = document.createElement("div");
+      threadMessages.style.display = "none";
+      msgContainer.openThread = (highlightTs) => {
+        threadMessages.style.display = "block";
+        if (threadMessages.children.length === 0) {
+          loadReplies(channel, message.ts, threadMessages, highlightTs);
+        }
+        showThread.innerText = hideText;
+      };
+      showThread.onclick = () => {
+        if (threadMessages.style.display === "none") {
+          msgContainer.openThread();
+        } else {
+          threadMessages.style.display = "none";
+          showThread.innerText = showText;
+        }
+      };
+      showThread.style.marginLeft = "40px";
+      showThread.style.marginBottom = "10px";
+      threadMessages.style.marginLeft = "40px";
+      msgContainer.appendChild(showThread);
+      msgContainer.appendChild(threadMessages);
+    }
+    msgContainer.style.marginBottom = "20px";
+    document.getElementById("messages").appendChild(msgContainer);
+    rendered[message.ts] = msgContainer;
+  }
+  if (messages.length === 0) {
+    document.getElementById("nomessages").style.display = "";
+  }
+  return rendered;
+}
+
+function fetchMessages(url) {
   document.getElementById("loading").style.display = "";
   document.getElementById("select-params").style.display = "none";
   document.getElementById("nomessages").style.display = "none";
-  fetch(`/messages?channel=${channel}&from=${from.getTime()}&to=${to.getTime()}`).then((response) => {
+  return fetch(url).then((response) => {
     if (!response.ok) {
       throw new Error(`GET /messages failed: ${response.status} ${response.statusText}`);
     }
@@ -77,53 +182,55 @@ function loadMessages(channel, from, to) {
   }).then((messages) => {
     document.getElementById("loading").style.display = "none";
     document.getElementById("error").style.display = "none";
-    for (let message of messages) {
-      let msgContainer = renderMessage(message);
-      if (message.thread) {
-        let showThread = document.createElement("button");
-        const showText = `Show ${message.thread.length} repl${message.thread.length === 1 ? "y" : "ies"}`;
-        const hideText = `Hide ${message.thread.length} repl${message.thread.length === 1 ? "y" : "ies"}`;
-        showThread.innerText = showText;
-        let threadMessages = document.createElement("div");
-        threadMessages.style.display = "none";
-        showThread.onclick = () => {
-          if (threadMessages.style.display === "none") {
-            threadMessages.style.display = "block";
-            if (threadMessages.children.length === 0) {
-              for (let reply of message.thread) {
-                let replyContainer = renderMessage(reply);
-                replyContainer.style.marginBottom = "10px";
-                threadMessages.appendChild(replyContainer);
-              }
-            }
-            showThread.innerText = hideText;
-          } else {
-            threadMessages.style.display = "none";
-            showThread.innerText = showText;
-          }
-        };
-        showThread.style.marginLeft = "40px";
-        showThread.style.marginBottom = "10px";
-        threadMessages.style.marginLeft = "40px";
-        msgContainer.appendChild(showThread);
-        msgContainer.appendChild(threadMessages);
-      }
-      msgContainer.style.marginBottom = "20px";
-      document.getElementById("messages").appendChild(msgContainer);
-    }
-    if (messages.length === 0) {
-      document.getElementById("nomessages").style.display = "";
-    }
+    return messages;
   }).catch((error) => {
     document.getElementById("loading").style.display = "none";
     document.getElementById("error").style.display = "block";
     console.log(error);
+    return [];
   });
 }
 
+function loadMessages(channel, from, to, hideSystem) {
+  const hide = hideSystem ? "&hide=all" : "";
+  fetchMessages(`/messages?channel=${channel}&from=${from.getTime()}&to=${to.getTime()}&threads=collapsed${hide}`)
+    .then((messages) => renderMessages(channel, messages));
+}
+
+// openPermalink shows the message from a resolved Slack permalink,
+// given by the channel, ts and thread parameters of the page's URL
+function openPermalink() {
+  const params = new URLSearchParams(window.location.search);
+  const channel = params.get("channel");
+  const ts = params.get("ts");
+  if (!channel || !ts) {
+    return;
+  }
+  const thread = params.get("thread");
+  document.getElementById("channel").value = channel;
+  selectedChannel = channel;
+  document.getElementById("messages").textContent = "";
+  fetchMessages(`/messages?channel=${channel}&around=${thread || ts}&limit=50&threads=collapsed`)
+    .then((messages) => {
+      const msgContainer = renderMessages(channel, messages)[thread || ts];
+      if (!msgContainer) {
+        return;
+      }
+      if (thread) {
+        msgContainer.openThread(ts);
+      } else {
+        highlight(msgContainer);
+        if (msgContainer.openThread) {
+          msgContainer.openThread();
+        }
+      }
+    });
+}
+
 let selectedChannel = "";
 let selectedFrom = new Date(0);
 let selectedTo = new Date(0);
+let selectedHideSystem = false;
 
 function tryLoadMessages() {
   const channel = document.getElementById("channel").value;
@@ -142,17 +249,20 @@ function tryLoadMessages() {
   const from = new Date(fromDay[0], fromDay[1] - 1, fromDay[2]);
   const toDay = toStr.split("-");
   const to = new Date(toDay[0], toDay[1] - 1, toDay[2]);
+  const hideSystem = document.getElementById("hide-system").checked;
   if (from.getTime() >= to.getTime()
     || (channel === selectedChannel
       && from.getTime() === selectedFrom.getTime()
-      && to.getTime() === selectedTo.getTime())) {
+      && to.getTime() === selectedTo.getTime()
+      && hideSystem === selectedHideSystem)) {
     return;
   }
   document.getElementById("messages").textContent = "";
-  loadMessages(channel, from, to);
+  loadMessages(channel, from, to, hideSystem);
   selectedChannel = channel;
   selectedFrom = from;
   selectedTo = to;
+  selectedHideSystem = hideSystem;
 }
 
 function uploadZip() {
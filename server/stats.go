@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slack-backer-upper/slack"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultUnansweredLimit = 100
+	defaultUnansweredHours = 24
+	defaultOnThisDayLimit  = 20
+)
+
+// defaultQuestionPattern matches messages asking questions
+var defaultQuestionPattern = regexp.MustCompile(`\?`)
+
+// parseStatsQuery reads the optional from, to and channels parameters
+// shared by the statistics endpoints
+func parseStatsQuery(query url.Values) (slack.StatsQuery, error) {
+	from, err := parseMillis(query, "from")
+	if err != nil {
+		return slack.StatsQuery{}, err
+	}
+	to, err := parseMillis(query, "to")
+	if err != nil {
+		return slack.StatsQuery{}, err
+	}
+	q := slack.StatsQuery{Channels: parseChannelList(query)}
+	if from != nil {
+		q.From = *from * 1000
+	}
+	if to != nil {
+		q.To = *to * 1000
+	}
+	return q, nil
+}
+
+func (s *Server) getChannelStats(res http.ResponseWriter, req *http.Request) {
+	q, err := parseStatsQuery(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := s.storage.GetChannelStats(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting channel stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(stats)
+}
+
+func (s *Server) getUserStats(res http.ResponseWriter, req *http.Request) {
+	q, err := parseStatsQuery(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := s.storage.GetUserStats(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting user stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(stats)
+}
+
+func (s *Server) getActivityStats(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	loc, err := parseLocation(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval := query.Get("interval")
+	switch interval {
+	case "":
+		interval = slack.IntervalDay
+	case slack.IntervalDay, slack.IntervalWeek, slack.IntervalMonth:
+	default:
+		http.Error(res, fmt.Sprintf("Invalid interval: %s", interval), http.StatusBadRequest)
+		return
+	}
+	times, err := s.storage.GetMessageCounts(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting activity stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	buckets, err := slack.ActivityBuckets(times, interval, loc)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting activity stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(buckets)
+}
+
+func (s *Server) getHourStats(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	loc, err := parseLocation(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	times, err := s.storage.GetMessageCounts(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting hour stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(slack.HourBuckets(times, loc))
+}
+
+func (s *Server) getCalendar(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Channels = []string{mux.Vars(req)["name"]}
+	loc, err := parseLocation(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hours := false
+	if h := query.Get("hours"); h != "" {
+		if hours, err = strconv.ParseBool(h); err != nil {
+			http.Error(res, fmt.Sprintf("Invalid hours: %s", h), http.StatusBadRequest)
+			return
+		}
+	}
+	times, err := s.storage.GetMessageCounts(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting calendar: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(slack.CalendarDays(times, loc, hours))
+}
+
+func (s *Server) getResponseStats(res http.ResponseWriter, req *http.Request) {
+	q, err := parseStatsQuery(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	threads, err := s.storage.GetThreadReplyTimes(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting response stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(slack.ResponseTimes(threads))
+}
+
+// listUnansweredQuestions finds top level messages matching pattern,
+// by default those with a question mark, without a reply from anyone
+// else within the given number of hours, or a reaction from anyone else
+func (s *Server) listUnansweredQuestions(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pattern := defaultQuestionPattern
+	if p := query.Get("pattern"); p != "" {
+		if pattern, err = regexp.Compile(p); err != nil {
+			http.Error(res, fmt.Sprintf("Invalid pattern: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	hours := float64(defaultUnansweredHours)
+	if h := query.Get("hours"); h != "" {
+		if hours, err = strconv.ParseFloat(h, 64); err != nil || hours <= 0 {
+			http.Error(res, fmt.Sprintf("Invalid hours: %s", h), http.StatusBadRequest)
+			return
+		}
+	}
+	limit, err := parseLimit(query, defaultUnansweredLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	within := time.Duration(hours * float64(time.Hour))
+	stored, err := s.storage.GetUnansweredQuestions(q, pattern, within, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting unanswered questions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	messages := make([]slack.ChannelMessage, len(stored))
+	for i, msg := range stored {
+		if messages[i], err = slack.ChannelMessageFromStored(msg); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting unanswered questions: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(messages)
+}
+
+// getInteractionGraph builds a graph of who replied to, mentioned and
+// reacted to whom, as JSON or, with format, a GraphML, GEXF or DOT download
+func (s *Server) getInteractionGraph(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	var write func(io.Writer, slack.Graph) error
+	var contentType string
+	switch format {
+	case "", "json":
+	case "graphml":
+		write, contentType = slack.WriteGraphML, "application/graphml+xml"
+	case "gexf":
+		write, contentType = slack.WriteGEXF, "application/gexf+xml"
+	case "dot":
+		write, contentType = slack.WriteDOT, "text/vnd.graphviz"
+	default:
+		http.Error(res, fmt.Sprintf("Invalid format: %s", format), http.StatusBadRequest)
+		return
+	}
+	interactions, err := s.storage.GetInteractions(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting interactions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	graph := slack.InteractionGraph(interactions)
+	if write == nil {
+		json.NewEncoder(res).Encode(graph)
+		return
+	}
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="interactions.%s"`, format))
+	if err = write(res, graph); err != nil {
+		log.Printf("Error writing interaction graph: %v", err)
+	}
+}
+
+// getOnThisDay lists the messages from the same day as date, or today,
+// in previous years with the most reactions and replies
+func (s *Server) getOnThisDay(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	loc, err := parseLocation(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	date := time.Now().In(loc)
+	if d := query.Get("date"); d != "" {
+		if date, err = time.ParseInLocation("2006-01-02", d, loc); err != nil {
+			http.Error(res, fmt.Sprintf("Invalid date: %s", d), http.StatusBadRequest)
+			return
+		}
+	}
+	limit, err := parseLimit(query, defaultOnThisDayLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.GetOnThisDay(date, parseChannelList(query), limit)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting messages on this day: %v", err), http.StatusInternalServerError)
+		return
+	}
+	messages := make([]slack.NotableMessage, len(stored))
+	for i, msg := range stored {
+		if messages[i], err = slack.NotableMessageFromStored(msg, date); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting messages on this day: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(messages)
+}
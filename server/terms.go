@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slack-backer-upper/slack"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDistinctiveLimit = 20
+	maxNGramLength          = 3
+)
+
+// parseWordList reads a comma separated list of words or phrases
+func parseWordList(query url.Values, name string) []string {
+	var words []string
+	for _, word := range strings.Split(query.Get(name), ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// getTermTrends counts how often each of the comma separated terms
+// was used per day, week or month
+func (s *Server) getTermTrends(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	terms := parseWordList(query, "terms")
+	if len(terms) == 0 {
+		http.Error(res, "Missing terms", http.StatusBadRequest)
+		return
+	}
+	for _, term := range terms {
+		if len(slack.Words(term)) == 0 {
+			http.Error(res, fmt.Sprintf("Invalid term: %s", term), http.StatusBadRequest)
+			return
+		}
+	}
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	loc, err := parseLocation(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval := query.Get("interval")
+	switch interval {
+	case "":
+		interval = slack.IntervalMonth
+	case slack.IntervalDay, slack.IntervalWeek, slack.IntervalMonth:
+	default:
+		http.Error(res, fmt.Sprintf("Invalid interval: %s", interval), http.StatusBadRequest)
+		return
+	}
+	texts, err := s.storage.GetMessageTexts(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting term trends: %v", err), http.StatusInternalServerError)
+		return
+	}
+	series, err := slack.TermTrends(texts, terms, interval, loc)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting term trends: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(series)
+}
+
+// getDistinctiveTerms finds the words and phrases of up to n words used
+// more in the messages matching the query than in the rest of the archive,
+// so the query must narrow the messages down by channel or period
+func (s *Server) getDistinctiveTerms(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if channel := strings.TrimPrefix(strings.TrimSpace(query.Get("channel")), "#"); channel != "" {
+		q.Channels = append(q.Channels, channel)
+	}
+	if len(q.Channels) == 0 && q.From == 0 && q.To == 0 {
+		http.Error(res, "Missing channel or period", http.StatusBadRequest)
+		return
+	}
+	n := 1
+	if nStr := query.Get("n"); nStr != "" {
+		if n, err = strconv.Atoi(nStr); err != nil || n < 1 || n > maxNGramLength {
+			http.Error(res, fmt.Sprintf("Invalid n: %s", nStr), http.StatusBadRequest)
+			return
+		}
+	}
+	defaults := true
+	if d := query.Get("default_stopwords"); d != "" {
+		if defaults, err = strconv.ParseBool(d); err != nil {
+			http.Error(res, fmt.Sprintf("Invalid default_stopwords: %s", d), http.StatusBadRequest)
+			return
+		}
+	}
+	stop := slack.StopWords(parseWordList(query, "stopwords"), defaults)
+	limit, err := parseLimit(query, defaultDistinctiveLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	texts, err := s.storage.GetMessageTexts(q)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting distinctive terms: %v", err), http.StatusInternalServerError)
+		return
+	}
+	archiveTexts, err := s.storage.GetMessageTexts(slack.StatsQuery{})
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting distinctive terms: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(res).Encode(slack.DistinctiveTerms(
+		slack.CountTerms(textsOf(texts), n, stop),
+		slack.CountTerms(textsOf(archiveTexts), n, stop),
+		limit,
+	))
+}
+
+func textsOf(messages []slack.TimedText) []string {
+	texts := make([]string, len(messages))
+	for i, msg := range messages {
+		texts[i] = msg.Text
+	}
+	return texts
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slack-backer-upper/slack"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultMentionLimit = 100
+
+// listMentions lists the messages that @mention a user, and with
+// broadcasts=true the !channel, !here and !everyone broadcasts too
+func (s *Server) listMentions(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	broadcasts := false
+	if b := query.Get("broadcasts"); b != "" {
+		if broadcasts, err = strconv.ParseBool(b); err != nil {
+			http.Error(res, fmt.Sprintf("Invalid broadcasts: %s", b), http.StatusBadRequest)
+			return
+		}
+	}
+	limit, err := parseLimit(query, defaultMentionLimit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := s.storage.GetMentions(mux.Vars(req)["id"], q, broadcasts, limit, offset)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting mentions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	mentions := make([]slack.Mention, len(stored))
+	for i, mention := range stored {
+		if mentions[i], err = slack.MentionFromStored(mention); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting mentions: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(res).Encode(mentions)
+}
+
+// listUserMessages pages through the messages and replies a user sent
+// in every channel, or the channels given, in chronological order
+func (s *Server) listUserMessages(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	params, err := parsePageParams(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.around != "" {
+		http.Error(res, "Invalid around: not supported for user messages", http.StatusBadRequest)
+		return
+	}
+	hidden, err := parseHiddenSubtypes(query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID := mux.Vars(req)["id"]
+	page, err := fetchPage(func(q slack.PageQuery) (slack.StoredPage, error) {
+		return s.storage.GetUserPage(userID, q)
+	}, params.query(parseChannelList(query), hidden), params)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Error getting user messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	messages := make([]slack.ChannelMessage, len(page.entries))
+	for i, entry := range page.entries {
+		if messages[i], err = slack.ChannelMessageFromStored(*entry.Parent); err != nil {
+			http.Error(res, fmt.Sprintf("Error getting user messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	setPageLinks(res, req, page)
+	json.NewEncoder(res).Encode(messages)
+}
@@ -0,0 +1,87 @@
+package slack
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// markupSpan is Slack's markup for links, @mentions and channels,
+// which autolinks never match inside of
+var markupSpan = regexp.MustCompile(`<[^>]*>`)
+
+// AutolinkRule links every piece of message text matching Pattern to URL.
+// In URL, $0 is the matched text and $1 or ${name} are its submatches
+// Goes in the db and is returned from the API / to the front end
+type AutolinkRule struct {
+	ID      int64  `json:"id"`
+	Pattern string `json:"pattern"`
+	URL     string `json:"url"`
+	Created int64  `json:"created"`
+}
+
+// CompiledAutolinkRule is an AutolinkRule ready to match messages with
+type CompiledAutolinkRule struct {
+	AutolinkRule
+	Regexp *regexp.Regexp
+}
+
+// Autolink is a reference in a message's text that an AutolinkRule links
+// Returned from the API / to the front end
+type Autolink struct {
+	RuleID int64  `json:"rule_id"`
+	Text   string `json:"text"`
+	URL    string `json:"url"`
+}
+
+// CompileAutolinkRule checks that a rule's pattern is a valid regular
+// expression that can't match empty text, and that it links to a web page
+func CompileAutolinkRule(rule AutolinkRule) (CompiledAutolinkRule, error) {
+	if rule.Pattern == "" {
+		return CompiledAutolinkRule{}, fmt.Errorf("Missing pattern")
+	}
+	re, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return CompiledAutolinkRule{}, fmt.Errorf("Invalid pattern: %v", err)
+	}
+	if re.MatchString("") {
+		return CompiledAutolinkRule{}, fmt.Errorf("Invalid pattern: %s matches empty text", rule.Pattern)
+	}
+	if !strings.HasPrefix(rule.URL, "https://") && !strings.HasPrefix(rule.URL, "http://") {
+		return CompiledAutolinkRule{}, fmt.Errorf("Invalid url: %s is not an http or https URL", rule.URL)
+	}
+	return CompiledAutolinkRule{AutolinkRule: rule, Regexp: re}, nil
+}
+
+// FindAutolinks finds the references in message text that the rules link,
+// once each, skipping any inside Slack's markup for links and mentions
+func FindAutolinks(text string, rules []CompiledAutolinkRule) []Autolink {
+	markup := markupSpan.FindAllStringIndex(text, -1)
+	inMarkup := func(start, end int) bool {
+		for _, span := range markup {
+			if start < span[1] && end > span[0] {
+				return true
+			}
+		}
+		return false
+	}
+	var autolinks []Autolink
+	seen := make(map[Autolink]bool)
+	for _, rule := range rules {
+		for _, match := range rule.Regexp.FindAllStringSubmatchIndex(text, -1) {
+			if inMarkup(match[0], match[1]) {
+				continue
+			}
+			autolink := Autolink{
+				RuleID: rule.ID,
+				Text:   text[match[0]:match[1]],
+				URL:    string(rule.Regexp.ExpandString(nil, rule.URL, text, match)),
+			}
+			if !seen[autolink] {
+				seen[autolink] = true
+				autolinks = append(autolinks, autolink)
+			}
+		}
+	}
+	return autolinks
+}
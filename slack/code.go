@@ -0,0 +1,83 @@
+package slack
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	codeFence    = regexp.MustCompile("(?s)```(.*?)```")
+	languageHint = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+#._-]{0,19}$`)
+	unescaper    = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+)
+
+// CodeBlock is a fenced code block from a message
+// Goes in the db
+type CodeBlock struct {
+	Language string
+	Code     string
+}
+
+// CodeResult is returned from the API / to the front end
+type CodeResult struct {
+	Channel   string `json:"channel"`
+	TS        string `json:"ts"`
+	ParentTS  string `json:"parent_ts"`
+	Timestamp uint64 `json:"timestamp"`
+	User      string `json:"user"`
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+}
+
+// StoredCodeResult is read from the db
+type StoredCodeResult struct {
+	Channel         string
+	Timestamp       string
+	ParentTimestamp string
+	User            string
+	Block           CodeBlock
+}
+
+// ExtractCodeBlocks finds the ```fenced``` code blocks in message text.
+// A single word alone on the opening line, as in ```bash, is taken as
+// the block's language, since Slack itself has no syntax for one
+func ExtractCodeBlocks(text string) []CodeBlock {
+	matches := codeFence.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	blocks := make([]CodeBlock, 0, len(matches))
+	for _, match := range matches {
+		code := unescaper.Replace(match[1])
+		var language string
+		if newline := strings.Index(code, "\n"); newline > 0 {
+			if firstLine := strings.TrimSpace(code[:newline]); languageHint.MatchString(firstLine) {
+				language = strings.ToLower(firstLine)
+				code = code[newline+1:]
+			}
+		}
+		code = strings.Trim(code, "\n")
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		blocks = append(blocks, CodeBlock{Language: language, Code: code})
+	}
+	return blocks
+}
+
+// CodeResultFromStored creates a CodeResult from a StoredCodeResult
+func CodeResultFromStored(result StoredCodeResult) (CodeResult, error) {
+	timestamp, err := TimestampSeconds(result.Timestamp)
+	if err != nil {
+		return CodeResult{}, err
+	}
+	return CodeResult{
+		Channel:   result.Channel,
+		TS:        result.Timestamp,
+		ParentTS:  result.ParentTimestamp,
+		Timestamp: timestamp,
+		User:      result.User,
+		Language:  result.Block.Language,
+		Code:      result.Block.Code,
+	}, nil
+}
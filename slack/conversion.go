@@ -2,23 +2,26 @@ package slack
 
 import (
 	"regexp"
-	"strconv"
 	"strings"
 )
 
 var (
 	isComment      = regexp.MustCompile("<@U[A-Z0-9]{8}> commented on .+")
 	atNotification = regexp.MustCompile("<@U[A-Z0-9]{8}>")
+	broadcast      = regexp.MustCompile(`<(!channel|!here|!everyone)(\|[^>]*)?>`)
 )
 
 // FilterRawMessage transforms a RawMessage into a StoredMessage
-// and replaces user IDs
+// and replaces user IDs. Mentions are indexed by user ID from the raw text
+// as well as being flattened to @DisplayName in the stored text, since
+// search, summaries, the digest and the knowledge base all use the text
+// as it reads and have no users to look the IDs up in
 func FilterRawMessage(message RawMessage, users map[string]StoredUser) StoredMessage {
-	var userid string
+	var userid, id string
 	if message.User != "" {
-		userid = message.User
+		userid, id = message.User, message.User
 	} else if isComment.MatchString(message.Text) {
-		userid = message.Text[2:11]
+		userid, id = message.Text[2:11], message.Text[2:11]
 	} else {
 		userid = message.Username
 	}
@@ -37,11 +40,20 @@ func FilterRawMessage(message RawMessage, users map[string]StoredUser) StoredMes
 			return "@<unknown>"
 		}),
 		User:            userid,
+		UserID:          id,
+		Subtype:         message.Subtype,
 		DisplayTopLevel: message.ParentTimestamp == "" || message.ParentTimestamp == message.Timestamp || message.Subtype == "thread_broadcast",
 	}
 
 	if message.ParentTimestamp != "" && message.ParentTimestamp != message.Timestamp {
 		ret.ParentTimestamp = message.ParentTimestamp
+	} else if message.ReplyCount > 0 {
+		ret.ReplyCount = message.ReplyCount
+		ret.LatestReply = message.LatestReply
+		ret.ReplyUsers = make([]string, len(message.ReplyUsers))
+		for i, replier := range message.ReplyUsers {
+			ret.ReplyUsers[i] = realName(replier, users)
+		}
 	}
 
 	attachments := make([]Attachment, 0, 4)
@@ -61,7 +73,7 @@ func FilterRawMessage(message RawMessage, users map[string]StoredUser) StoredMes
 				})
 			} else {
 				attachments = append(attachments, Attachment{
-					Title: "This file was deleted.",
+					Title: deletedFileTitle,
 				})
 			}
 		}
@@ -69,31 +81,270 @@ func FilterRawMessage(message RawMessage, users map[string]StoredUser) StoredMes
 	if len(attachments) > 0 {
 		ret.Attachments = attachments
 	}
-	if message.Reacts != nil {
-		ret.Reacts = make(map[string][]string)
-		for _, reacc := range message.Reacts {
-			names := make([]string, len(reacc.Users))
-			for i, s := range reacc.Users {
-				names[i] = users[s].RealName
-			}
-			ret.Reacts[reacc.Name] = names
+	ret.HasFile = len(message.Files) > 0
+	ret.HasLink = strings.Contains(message.Text, "<http")
+	for _, attach := range message.Attachments {
+		if attach.URL != "" {
+			ret.HasLink = true
+		}
+	}
+	mentioned := make(map[string]bool)
+	for _, match := range atNotification.FindAllString(message.Text, -1) {
+		if !mentioned[match[2:11]] {
+			mentioned[match[2:11]] = true
+			ret.Mentions = append(ret.Mentions, match[2:11])
+		}
+	}
+	for _, match := range broadcast.FindAllStringSubmatch(message.Text, -1) {
+		if !mentioned[match[1]] {
+			mentioned[match[1]] = true
+			ret.Mentions = append(ret.Mentions, match[1])
+		}
+	}
+	for _, reacc := range message.Reacts {
+		if reacc.Count < len(reacc.Users) {
+			reacc.Count = len(reacc.Users)
 		}
+		ret.Reactions = append(ret.Reactions, reacc)
 	}
 	return ret
 }
 
 // ParentMessageFromStored creates a ParentMessage from a StoredMessage
 func ParentMessageFromStored(message StoredMessage) (ParentMessage, error) {
-	splitTimestamp := strings.Split(message.Timestamp, ".")
-	timestamp, err := strconv.ParseUint(splitTimestamp[0], 10, 64)
+	timestamp, err := TimestampSeconds(message.Timestamp)
 	if err != nil {
 		return ParentMessage{}, err
 	}
+	reactions := GroupReactions(message.Reactions)
+	if reactions == nil {
+		reactions = legacyReactions(message.Reacts)
+	}
 	return ParentMessage{
 		Timestamp:   timestamp,
+		TS:          message.Timestamp,
 		Text:        message.Text,
 		User:        message.User,
 		Attachments: message.Attachments,
-		Reacts:      message.Reacts,
+		Reacts:      ReactsFromReactions(reactions),
+		Reactions:   reactions,
+		Subtype:     message.Subtype,
+	}, nil
+}
+
+// ThreadMessageFromStored creates a ThreadMessage from a StoredMessage
+func ThreadMessageFromStored(message StoredMessage) (ThreadMessage, error) {
+	parent, err := ParentMessageFromStored(message)
+	if err != nil {
+		return ThreadMessage{}, err
+	}
+	return ThreadMessage{
+		Timestamp:     parent.Timestamp,
+		TS:            parent.TS,
+		Text:          parent.Text,
+		User:          parent.User,
+		Attachments:   parent.Attachments,
+		Reacts:        parent.Reacts,
+		Reactions:     parent.Reactions,
+		Subtype:       parent.Subtype,
+		SentToChannel: message.DisplayTopLevel,
+	}, nil
+}
+
+// ChannelMessageFromStored creates a ChannelMessage from a StoredMessage
+func ChannelMessageFromStored(message StoredMessage) (ChannelMessage, error) {
+	parent, err := ParentMessageFromStored(message)
+	if err != nil {
+		return ChannelMessage{}, err
+	}
+	return ChannelMessage{
+		Channel:       message.Channel,
+		ParentTS:      message.ParentTimestamp,
+		ParentMessage: parent,
 	}, nil
 }
+
+// Snippets read from the db mark where matches start and end with these
+// control characters, which can't be confused with the message's own text
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+var snippetMarks = strings.NewReplacer(SnippetMatchStart, "<mark>", SnippetMatchEnd, "</mark>")
+
+// snippetHTML escapes the text of a snippet for HTML,
+// then wraps its matches in <mark> tags
+func snippetHTML(snippet string) string {
+	return snippetMarks.Replace(escaper.Replace(unescaper.Replace(snippet)))
+}
+
+// SearchResultFromStored creates a SearchResult from a StoredSearchResult
+func SearchResultFromStored(result StoredSearchResult) (SearchResult, error) {
+	message, err := ChannelMessageFromStored(result.Message)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	ret := SearchResult{
+		ChannelMessage: message,
+		Snippet:        snippetHTML(result.Snippet),
+	}
+	if result.Parent != nil {
+		parent, err := ParentMessageFromStored(*result.Parent)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		ret.ThreadParent = &parent
+	}
+	return ret, nil
+}
+
+// MentionFromStored creates a Mention from a StoredMention
+func MentionFromStored(mention StoredMention) (Mention, error) {
+	message, err := ChannelMessageFromStored(mention.Message)
+	if err != nil {
+		return Mention{}, err
+	}
+	ret := Mention{ChannelMessage: message, Broadcast: mention.Broadcast}
+	if mention.Parent != nil {
+		parent, err := ParentMessageFromStored(*mention.Parent)
+		if err != nil {
+			return Mention{}, err
+		}
+		ret.ThreadParent = &parent
+	}
+	return ret, nil
+}
+
+// PlaceholderParent creates an empty ParentMessage standing in for
+// the parent of a thread whose parent message is not in the archive
+func PlaceholderParent(timestamp string) (ParentMessage, error) {
+	return ParentMessageFromStored(StoredMessage{Timestamp: timestamp})
+}
+
+// ThreadSummaryFromStored creates a ThreadSummary from a StoredThread
+func ThreadSummaryFromStored(thread StoredThread) (ThreadSummary, error) {
+	var parent ParentMessage
+	var err error
+	if thread.Parent != nil {
+		parent, err = ParentMessageFromStored(*thread.Parent)
+	} else {
+		parent, err = PlaceholderParent(thread.Timestamp)
+		parent.Orphaned = true
+	}
+	if err != nil {
+		return ThreadSummary{}, err
+	}
+	summary := ThreadSummary{
+		Parent:       parent,
+		ReplyCount:   thread.ReplyCount,
+		Participants: thread.Participants,
+		LatestReply:  uint64(thread.LatestReply / 1e6),
+	}
+	if thread.LatestReply > 0 {
+		summary.LatestReplyTS = MicrosTimestamp(thread.LatestReply)
+	}
+	return summary, nil
+}
+
+// RelatedThreadFromStored creates a RelatedThread from a StoredRelatedThread
+func RelatedThreadFromStored(related StoredRelatedThread) (RelatedThread, error) {
+	summary, err := ThreadSummaryFromStored(related.Thread)
+	if err != nil {
+		return RelatedThread{}, err
+	}
+	return RelatedThread{Channel: related.Channel, Score: related.Score, ThreadSummary: summary}, nil
+}
+
+// FilterRawChannel transforms a RawChannel into a StoredChannel
+// and replaces the creator's user ID
+func FilterRawChannel(channel RawChannel, users map[string]StoredUser) StoredChannel {
+	pins := make([]Pin, len(channel.Pins))
+	for i, pin := range channel.Pins {
+		pins[i] = Pin{
+			Timestamp: pin.Timestamp,
+			PinnedBy:  realName(pin.User, users),
+			PinnedAt:  pin.Created,
+		}
+	}
+	bookmarks := make([]Bookmark, 0, len(channel.Bookmarks))
+	for _, bookmark := range channel.Bookmarks {
+		if bookmark.Link == "" {
+			continue
+		}
+		bookmarks = append(bookmarks, Bookmark{
+			ID:      bookmark.ID,
+			Title:   bookmark.Title,
+			Link:    bookmark.Link,
+			Emoji:   bookmark.Emoji,
+			Created: bookmark.DateCreated,
+			Creator: realName(bookmark.UpdatedBy, users),
+		})
+	}
+	return StoredChannel{
+		ID:        channel.ID,
+		Name:      channel.Name,
+		Created:   channel.Created,
+		Creator:   realName(channel.Creator, users),
+		Archived:  channel.IsArchived,
+		Topic:     channel.Topic.Value,
+		Purpose:   channel.Purpose.Value,
+		Pins:      pins,
+		Bookmarks: bookmarks,
+	}
+}
+
+// PinFromRaw extracts the pin of a message that is currently pinned,
+// which carries pinned_to
+func PinFromRaw(message RawMessage) (Pin, bool) {
+	if len(message.PinnedTo) > 0 {
+		return Pin{Timestamp: message.Timestamp}, true
+	}
+	return Pin{}, false
+}
+
+// PinEventFromRaw extracts who pinned what and when from a pinned_item
+// system message. PinnedBy is empty if the message doesn't say who
+func PinEventFromRaw(message RawMessage, users map[string]StoredUser) (Pin, bool) {
+	if message.Subtype != "pinned_item" {
+		return Pin{}, false
+	}
+	for _, attach := range message.Attachments {
+		if attach.Timestamp == "" {
+			continue
+		}
+		pinnedAt, err := TimestampSeconds(message.Timestamp)
+		if err != nil {
+			return Pin{}, false
+		}
+		return Pin{
+			Timestamp: attach.Timestamp,
+			PinnedBy:  realName(message.User, users),
+			PinnedAt:  int64(pinnedAt),
+		}, true
+	}
+	return Pin{}, false
+}
+
+// PinnedMessageFromStored creates a PinnedMessage from a Pin
+func PinnedMessageFromStored(pin Pin) (PinnedMessage, error) {
+	ret := PinnedMessage{
+		PinnedBy: pin.PinnedBy,
+		PinnedAt: pin.PinnedAt,
+	}
+	if pin.Message != nil {
+		message, err := ParentMessageFromStored(*pin.Message)
+		if err != nil {
+			return PinnedMessage{}, err
+		}
+		ret.Message = &message
+	}
+	return ret, nil
+}
+
+func realName(userid string, users map[string]StoredUser) string {
+	if user, ok := users[userid]; ok {
+		return user.RealName
+	}
+	return userid
+}
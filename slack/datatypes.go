@@ -3,9 +3,10 @@ package slack
 // Attachment is what we care about from attachments
 // Also goes in the DB
 type Attachment struct {
-	URL      string `json:"from_url"`
-	Fallback string `json:"fallback"`
-	Title    string `json:"title"`
+	URL       string `json:"from_url"`
+	Fallback  string `json:"fallback"`
+	Title     string `json:"title"`
+	Timestamp string `json:"ts,omitempty"`
 }
 
 // File is what we care about from file uploads
@@ -15,42 +16,154 @@ type File struct {
 }
 
 // React is what we care about from reaccs
+// Also goes in the db, where Users are user IDs until they are read back out
 type React struct {
 	Name  string   `json:"name"`
 	Users []string `json:"users"`
+	Count int      `json:"count"`
+}
+
+// Reaction is every react with one emoji, including its skin tone variants
+// Returned from the API / to the front end
+type Reaction struct {
+	Emoji    string   `json:"emoji"`
+	Count    int      `json:"count"`
+	Users    []string `json:"users"`
+	Variants []string `json:"variants"`
 }
 
 // StoredMessage goes in the db
+// User is the sender's name, and UserID their ID if known.
+// Channel is only filled in when reading messages from several channels,
+// and Mentions, the IDs of the users @mentioned and any !channel, !here
+// or !everyone broadcasts, only when importing
 type StoredMessage struct {
+	Channel         string
 	Timestamp       string
 	Text            string
 	User            string
+	UserID          string
+	Subtype         string
 	ParentTimestamp string
 	DisplayTopLevel bool
 	Attachments     []Attachment
 	Reacts          map[string][]string
+	Reactions       []React
+	HasLink         bool
+	HasFile         bool
+	ReplyCount      int
+	ReplyUsers      []string
+	LatestReply     string
+	Mentions        []string
 }
 
 // ThreadMessage is returned from the API / to the front end
 type ThreadMessage struct {
 	Timestamp     uint64              `json:"timestamp"`
+	TS            string              `json:"ts"`
 	Text          string              `json:"text"`
 	User          string              `json:"user"`
 	Attachments   []Attachment        `json:"attachments"`
 	Reacts        map[string][]string `json:"reacts"`
+	Reactions     []Reaction          `json:"reactions"`
+	Subtype       string              `json:"subtype"`
 	SentToChannel bool                `json:"sent"`
+	Autolinks     []Autolink          `json:"autolinks,omitempty"`
 }
 
 // ParentMessage is returned from the API / to the front end
+// ReplyCount is only set in message lists, where Thread may be collapsed,
+// Summary only when a message list's threads are summarised, and Autolinks
+// only in message lists and search results
 type ParentMessage struct {
 	Timestamp   uint64              `json:"timestamp"`
+	TS          string              `json:"ts"`
 	Text        string              `json:"text"`
 	User        string              `json:"user"`
 	Attachments []Attachment        `json:"attachments"`
 	Reacts      map[string][]string `json:"reacts"`
+	Reactions   []Reaction          `json:"reactions"`
+	Subtype     string              `json:"subtype"`
+	Orphaned    bool                `json:"orphaned"`
+	ReplyCount  int                 `json:"reply_count,omitempty"`
+	Summary     *Summary            `json:"summary,omitempty"`
+	Autolinks   []Autolink          `json:"autolinks,omitempty"`
 	Thread      []ThreadMessage     `json:"thread"`
 }
 
+// StoredSearchResult is read from the db
+// Parent is the message a reply is in the thread of, if it is in the archive
+type StoredSearchResult struct {
+	Message StoredMessage
+	Snippet string
+	Parent  *StoredMessage
+}
+
+// SearchResult is returned from the API / to the front end
+type SearchResult struct {
+	ChannelMessage
+	Snippet      string         `json:"snippet"`
+	ThreadParent *ParentMessage `json:"thread_parent"`
+}
+
+// StoredMention is read from the db
+// Broadcast is the !channel, !here or !everyone broadcast
+// that mentioned the user, if they were not mentioned directly
+type StoredMention struct {
+	Message   StoredMessage
+	Parent    *StoredMessage
+	Broadcast string
+}
+
+// Mention is returned from the API / to the front end
+type Mention struct {
+	ChannelMessage
+	Broadcast    string         `json:"broadcast"`
+	ThreadParent *ParentMessage `json:"thread_parent"`
+}
+
+// StoredRelatedThread is read from the db
+type StoredRelatedThread struct {
+	Channel string
+	Score   float64
+	Thread  StoredThread
+}
+
+// RelatedThread is returned from the API / to the front end
+// Score is the cosine similarity of the threads' text, from 0 to 1
+type RelatedThread struct {
+	Channel string  `json:"channel"`
+	Score   float64 `json:"score"`
+	ThreadSummary
+}
+
+// ChannelMessage is a message returned outside of its channel's message list
+// ParentTS is the ts of the thread the message replies to, if any
+type ChannelMessage struct {
+	Channel  string `json:"channel"`
+	ParentTS string `json:"parent_ts"`
+	ParentMessage
+}
+
+// StoredThread is read from the db
+// Parent is nil when the thread's parent message is not in the archive
+type StoredThread struct {
+	Timestamp    string
+	Parent       *StoredMessage
+	ReplyCount   int
+	Participants []string
+	LatestReply  int64
+}
+
+// ThreadSummary is returned from the API / to the front end
+type ThreadSummary struct {
+	Parent        ParentMessage `json:"parent"`
+	ReplyCount    int           `json:"reply_count"`
+	Participants  []string      `json:"participants"`
+	LatestReply   uint64        `json:"latest_reply"`
+	LatestReplyTS string        `json:"latest_reply_ts"`
+}
+
 // RawMessage is what we care about from Slack
 type RawMessage struct {
 	Timestamp       string       `json:"ts"`
@@ -59,6 +172,13 @@ type RawMessage struct {
 	Username        string       `json:"username"`
 	ParentTimestamp string       `json:"thread_ts"`
 	Subtype         string       `json:"subtype"`
+	Topic           string       `json:"topic"`
+	Purpose         string       `json:"purpose"`
+	Name            string       `json:"name"`
+	PinnedTo        []string     `json:"pinned_to"`
+	ReplyCount      int          `json:"reply_count"`
+	ReplyUsers      []string     `json:"reply_users"`
+	LatestReply     string       `json:"latest_reply"`
 	Attachments     []Attachment `json:"attachments"`
 	Files           []File       `json:"files"`
 	Reacts          []React      `json:"reactions"`
@@ -78,3 +198,140 @@ type RawUser struct {
 
 // Users is an alias for a map from user IDs to StoredUsers
 type Users map[string]StoredUser
+
+// ChannelTopic is what we care about from a channel's topic or purpose
+type ChannelTopic struct {
+	Value   string `json:"value"`
+	Creator string `json:"creator"`
+	LastSet int64  `json:"last_set"`
+}
+
+// RawChannel is what we care about from Slack's channels.json
+type RawChannel struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Created    int64         `json:"created"`
+	Creator    string        `json:"creator"`
+	IsArchived bool          `json:"is_archived"`
+	Topic      ChannelTopic  `json:"topic"`
+	Purpose    ChannelTopic  `json:"purpose"`
+	Pins       []RawPin      `json:"pins"`
+	Bookmarks  []RawBookmark `json:"bookmarks"`
+}
+
+// RawPin is what we care about from a channel's pins
+type RawPin struct {
+	Timestamp string `json:"id"`
+	Created   int64  `json:"created"`
+	User      string `json:"user"`
+}
+
+// RawBookmark is what we care about from a channel's bookmarks
+type RawBookmark struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Link        string `json:"link"`
+	Emoji       string `json:"emoji"`
+	DateCreated int64  `json:"date_created"`
+	UpdatedBy   string `json:"last_updated_by_user_id"`
+}
+
+// StoredChannel goes in the db
+type StoredChannel struct {
+	ID        string
+	Name      string
+	Created   int64
+	Creator   string
+	Archived  bool
+	Topic     string
+	Purpose   string
+	Pins      []Pin
+	Bookmarks []Bookmark
+}
+
+// Pin goes in the db
+// Message is only filled in when reading pins back out
+type Pin struct {
+	Timestamp string
+	PinnedBy  string
+	PinnedAt  int64
+	Message   *StoredMessage
+}
+
+// PinnedMessage is returned from the API / to the front end
+type PinnedMessage struct {
+	PinnedBy string         `json:"pinned_by"`
+	PinnedAt int64          `json:"pinned_at"`
+	Message  *ParentMessage `json:"message"`
+}
+
+// Bookmark is a link saved to a channel
+// Goes in the db and is returned from the API / to the front end
+type Bookmark struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Link    string `json:"link"`
+	Emoji   string `json:"emoji"`
+	Created int64  `json:"created"`
+	Creator string `json:"creator"`
+}
+
+// ChannelEvent is a change to a channel's metadata
+// Goes in the db and is returned from the API / to the front end
+type ChannelEvent struct {
+	Timestamp uint64 `json:"timestamp"`
+	Field     string `json:"field"`
+	Value     string `json:"value"`
+	User      string `json:"user"`
+}
+
+// Channel is returned from the API / to the front end
+type Channel struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Created  int64          `json:"created"`
+	Creator  string         `json:"creator"`
+	Archived bool           `json:"archived"`
+	Topic    string         `json:"topic"`
+	Purpose  string         `json:"purpose"`
+	History  []ChannelEvent `json:"history"`
+}
+
+// PagePosition is a position in a message list, ordered by time
+// and then by channel when messages in different channels were sent
+// in the same microsecond
+type PagePosition struct {
+	Micros  int64
+	Channel string
+}
+
+// PageQuery selects a page of the message lists of Channels, or of every
+// channel if there are none. From and To bound the lists in microseconds,
+// From inclusively and To exclusively unless it is 0.
+// The page starts after Cursor, or ends before it if NewestFirst is set.
+// IncludeCursor includes the message at the cursor in a page after it
+type PageQuery struct {
+	Channels       []string
+	From, To       int64
+	Cursor         *PagePosition
+	IncludeCursor  bool
+	NewestFirst    bool
+	Limit          int
+	HiddenSubtypes []string
+}
+
+// StoredPageEntry is a parent message in a page read from the db
+// Parent is nil for the head of a thread whose parent is not in the archive
+type StoredPageEntry struct {
+	Position  PagePosition
+	Timestamp string
+	Parent    *StoredMessage
+}
+
+// StoredPage is a page of message lists read from the db
+// Entries are in the order requested, and More is set
+// if there are further entries in the same direction
+type StoredPage struct {
+	Entries []StoredPageEntry
+	More    bool
+}
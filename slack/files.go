@@ -0,0 +1,136 @@
+package slack
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Kinds of attachment: files by their type, previews of links,
+// and other attachments such as shared messages
+const (
+	KindImage        = "image"
+	KindVideo        = "video"
+	KindAudio        = "audio"
+	KindPDF          = "pdf"
+	KindDocument     = "document"
+	KindSpreadsheet  = "spreadsheet"
+	KindPresentation = "presentation"
+	KindArchive      = "archive"
+	KindFile         = "file"
+	KindLink         = "link"
+	KindAttachment   = "attachment"
+)
+
+// deletedFileTitle is the title of attachments for deleted files
+const deletedFileTitle = "This file was deleted."
+
+var extensionKinds = map[string]string{
+	"png": KindImage, "jpg": KindImage, "jpeg": KindImage, "gif": KindImage, "bmp": KindImage,
+	"svg": KindImage, "webp": KindImage, "heic": KindImage, "tif": KindImage, "tiff": KindImage,
+	"mp4": KindVideo, "mov": KindVideo, "avi": KindVideo, "mkv": KindVideo, "webm": KindVideo,
+	"mp3": KindAudio, "wav": KindAudio, "m4a": KindAudio, "ogg": KindAudio, "flac": KindAudio,
+	"pdf": KindPDF,
+	"doc": KindDocument, "docx": KindDocument, "odt": KindDocument, "rtf": KindDocument,
+	"txt": KindDocument, "md": KindDocument,
+	"xls": KindSpreadsheet, "xlsx": KindSpreadsheet, "ods": KindSpreadsheet, "csv": KindSpreadsheet,
+	"ppt": KindPresentation, "pptx": KindPresentation, "odp": KindPresentation, "key": KindPresentation,
+	"zip": KindArchive, "tar": KindArchive, "gz": KindArchive, "tgz": KindArchive, "7z": KindArchive,
+	"rar": KindArchive,
+}
+
+// FileQuery narrows down the attachments listed
+// User is a user's ID or name, and Types are kinds or file extensions.
+// From and To bound the messages in microseconds, From inclusively and
+// To exclusively unless it is 0
+type FileQuery struct {
+	Channels []string
+	User     string
+	Types    []string
+	From, To int64
+}
+
+// StoredFile is read from the db
+type StoredFile struct {
+	Channel         string
+	Timestamp       string
+	ParentTimestamp string
+	User            string
+	Attachment
+}
+
+// FileResult is returned from the API / to the front end
+// Channel, TS and ParentTS are of the message the attachment came from
+type FileResult struct {
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	Fallback  string `json:"fallback"`
+	Extension string `json:"extension"`
+	Kind      string `json:"kind"`
+	Deleted   bool   `json:"deleted"`
+	Channel   string `json:"channel"`
+	TS        string `json:"ts"`
+	ParentTS  string `json:"parent_ts"`
+	Timestamp uint64 `json:"timestamp"`
+	User      string `json:"user"`
+}
+
+// IsFile checks whether an attachment is an uploaded file,
+// rather than a preview of a link
+func IsFile(attach Attachment) bool {
+	return strings.Contains(attach.URL, "/files/") || attach.Title == deletedFileTitle
+}
+
+// AttachmentKind finds the lower case file extension of an attachment,
+// from its URL or title, and what kind of attachment it is
+func AttachmentKind(attach Attachment) (extension, kind string) {
+	if !IsFile(attach) {
+		if attach.URL != "" {
+			return "", KindLink
+		}
+		return "", KindAttachment
+	}
+	name := attach.Title
+	if parsed, err := url.Parse(attach.URL); err == nil && path.Ext(parsed.Path) != "" {
+		name = parsed.Path
+	}
+	extension = strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if kind = extensionKinds[extension]; kind == "" {
+		kind = KindFile
+	}
+	return extension, kind
+}
+
+// NormalizeTypes lower cases the kinds and file extensions in types,
+// dropping the dot before extensions and any empty types
+func NormalizeTypes(types []string) []string {
+	normalized := make([]string, 0, len(types))
+	for _, t := range types {
+		if t = strings.ToLower(strings.TrimPrefix(t, ".")); t != "" {
+			normalized = append(normalized, t)
+		}
+	}
+	return normalized
+}
+
+// FileResultFromStored creates a FileResult from a StoredFile
+func FileResultFromStored(file StoredFile) (FileResult, error) {
+	timestamp, err := TimestampSeconds(file.Timestamp)
+	if err != nil {
+		return FileResult{}, err
+	}
+	extension, kind := AttachmentKind(file.Attachment)
+	return FileResult{
+		Title:     file.Title,
+		URL:       file.URL,
+		Fallback:  file.Fallback,
+		Extension: extension,
+		Kind:      kind,
+		Deleted:   file.Title == deletedFileTitle && file.URL == "",
+		Channel:   file.Channel,
+		TS:        file.Timestamp,
+		ParentTS:  file.ParentTimestamp,
+		Timestamp: timestamp,
+		User:      file.User,
+	}, nil
+}
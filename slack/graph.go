@@ -0,0 +1,268 @@
+package slack
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Kinds of interaction between users
+const (
+	InteractionReply    = "reply"
+	InteractionMention  = "mention"
+	InteractionReaction = "reaction"
+)
+
+// Interaction is read from the db
+// Source replied to, mentioned or reacted to Target Count times
+type Interaction struct {
+	Source string
+	Target string
+	Kind   string
+	Count  int
+}
+
+// Graph is returned from the API / to the front end
+// Edges are directed from the user who interacted to the user they
+// interacted with, and weighted by the number of interactions
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is returned from the API / to the front end
+// Weight is the number of interactions the user took part in
+type GraphNode struct {
+	ID     string `json:"id"`
+	Label  string `json:"label"`
+	Weight int    `json:"weight"`
+}
+
+// GraphEdge is returned from the API / to the front end
+type GraphEdge struct {
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Weight    int    `json:"weight"`
+	Replies   int    `json:"replies"`
+	Mentions  int    `json:"mentions"`
+	Reactions int    `json:"reactions"`
+}
+
+// InteractionGraph builds a graph of who interacted with whom,
+// with a node for each user sorted by name
+func InteractionGraph(interactions []Interaction) Graph {
+	weights := make(map[string]int)
+	edges := make(map[[2]string]*GraphEdge)
+	keys := make([][2]string, 0, len(interactions))
+	for _, i := range interactions {
+		key := [2]string{i.Source, i.Target}
+		edge, ok := edges[key]
+		if !ok {
+			edge = &GraphEdge{}
+			edges[key] = edge
+			keys = append(keys, key)
+		}
+		switch i.Kind {
+		case InteractionReply:
+			edge.Replies += i.Count
+		case InteractionMention:
+			edge.Mentions += i.Count
+		case InteractionReaction:
+			edge.Reactions += i.Count
+		}
+		edge.Weight += i.Count
+		weights[i.Source] += i.Count
+		weights[i.Target] += i.Count
+	}
+	users := make([]string, 0, len(weights))
+	for user := range weights {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	ids := make(map[string]string, len(users))
+	graph := Graph{Nodes: make([]GraphNode, len(users)), Edges: make([]GraphEdge, len(keys))}
+	for i, user := range users {
+		ids[user] = fmt.Sprintf("n%d", i)
+		graph.Nodes[i] = GraphNode{ID: ids[user], Label: user, Weight: weights[user]}
+	}
+	for i, key := range keys {
+		graph.Edges[i] = *edges[key]
+		graph.Edges[i].Source = ids[key[0]]
+		graph.Edges[i].Target = ids[key[1]]
+	}
+	return graph
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// WriteGraphML writes the graph in GraphML
+func WriteGraphML(w io.Writer, g Graph) error {
+	doc := graphMLDocument{Keys: []graphMLKey{
+		{ID: "label", For: "node", Name: "label", Type: "string"},
+		{ID: "interactions", For: "node", Name: "interactions", Type: "int"},
+		{ID: "weight", For: "edge", Name: "weight", Type: "int"},
+		{ID: "replies", For: "edge", Name: "replies", Type: "int"},
+		{ID: "mentions", For: "edge", Name: "mentions", Type: "int"},
+		{ID: "reactions", For: "edge", Name: "reactions", Type: "int"},
+	}}
+	doc.Graph.EdgeDefault = "directed"
+	for _, node := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: node.ID, Data: []graphMLData{
+			{Key: "label", Value: node.Label},
+			{Key: "interactions", Value: fmt.Sprint(node.Weight)},
+		}})
+	}
+	for i, edge := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID: fmt.Sprintf("e%d", i), Source: edge.Source, Target: edge.Target, Data: []graphMLData{
+				{Key: "weight", Value: fmt.Sprint(edge.Weight)},
+				{Key: "replies", Value: fmt.Sprint(edge.Replies)},
+				{Key: "mentions", Value: fmt.Sprint(edge.Mentions)},
+				{Key: "reactions", Value: fmt.Sprint(edge.Reactions)},
+			},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value int    `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     string      `xml:"id,attr"`
+	Source string      `xml:"source,attr"`
+	Target string      `xml:"target,attr"`
+	Weight int         `xml:"weight,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfDocument struct {
+	XMLName xml.Name `xml:"http://gexf.net/1.3 gexf"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+		Attributes      []gexfAttributes `xml:"attributes"`
+		Nodes           []gexfNode       `xml:"nodes>node"`
+		Edges           []gexfEdge       `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+// WriteGEXF writes the graph in GEXF
+func WriteGEXF(w io.Writer, g Graph) error {
+	doc := gexfDocument{Version: "1.3"}
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Attributes = []gexfAttributes{{
+		Class:      "node",
+		Attributes: []gexfAttribute{{ID: "interactions", Title: "interactions", Type: "integer"}},
+	}, {
+		Class: "edge",
+		Attributes: []gexfAttribute{
+			{ID: "replies", Title: "replies", Type: "integer"},
+			{ID: "mentions", Title: "mentions", Type: "integer"},
+			{ID: "reactions", Title: "reactions", Type: "integer"},
+		},
+	}}
+	for _, node := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{
+			ID: node.ID, Label: node.Label, Values: []gexfValue{{For: "interactions", Value: node.Weight}},
+		})
+	}
+	for i, edge := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID: fmt.Sprintf("e%d", i), Source: edge.Source, Target: edge.Target, Weight: edge.Weight,
+			Values: []gexfValue{
+				{For: "replies", Value: edge.Replies},
+				{For: "mentions", Value: edge.Mentions},
+				{For: "reactions", Value: edge.Reactions},
+			},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// dotQuote quotes a DOT identifier
+func dotQuote(id string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(id) + `"`
+}
+
+// WriteDOT writes the graph in the DOT language of Graphviz
+func WriteDOT(w io.Writer, g Graph) error {
+	var b strings.Builder
+	b.WriteString("digraph interactions {\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&b, "  %s [label=%s, interactions=%d];\n", node.ID, dotQuote(node.Label), node.Weight)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s [weight=%d, replies=%d, mentions=%d, reactions=%d];\n",
+			edge.Source, edge.Target, edge.Weight, edge.Replies, edge.Mentions, edge.Reactions)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
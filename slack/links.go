@@ -0,0 +1,109 @@
+package slack
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var linkMarkup = regexp.MustCompile(`<(https?://[^|>\s]+)(?:\|([^>]*))?>`)
+
+// Link is a URL shared in a message
+type Link struct {
+	URL    string
+	Label  string
+	Domain string
+}
+
+// LinkQuery narrows down the links listed
+// Domain matches its subdomains too, and User is a user's ID or name.
+// From and To bound the links in microseconds, From inclusively and
+// To exclusively unless it is 0
+type LinkQuery struct {
+	Domain   string
+	Channels []string
+	User     string
+	From, To int64
+}
+
+// StoredLink is read from the db
+type StoredLink struct {
+	Channel   string
+	Timestamp string
+	User      string
+	Link
+}
+
+// LinkResult is returned from the API / to the front end
+type LinkResult struct {
+	URL       string `json:"url"`
+	Label     string `json:"label"`
+	Domain    string `json:"domain"`
+	Channel   string `json:"channel"`
+	TS        string `json:"ts"`
+	Timestamp uint64 `json:"timestamp"`
+	User      string `json:"user"`
+}
+
+// SharedLink is returned from the API / to the front end
+// Times are in seconds
+type SharedLink struct {
+	URL         string `json:"url"`
+	Label       string `json:"label"`
+	Domain      string `json:"domain"`
+	Shares      int    `json:"shares"`
+	Channels    int    `json:"channels"`
+	Users       int    `json:"users"`
+	FirstShared uint64 `json:"first_shared"`
+	LastShared  uint64 `json:"last_shared"`
+}
+
+// LinkDomain finds the domain of a URL for grouping links,
+// lower case and without any www. prefix
+func LinkDomain(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// ExtractLinks finds the URLs in a message's text and attachments,
+// in the order they appear, leaving out repeats of the same URL
+func ExtractLinks(text string, attachments []Attachment) []Link {
+	links := make([]Link, 0)
+	seen := make(map[string]bool)
+	add := func(link, label string) {
+		if seen[link] {
+			return
+		}
+		seen[link] = true
+		links = append(links, Link{URL: link, Label: label, Domain: LinkDomain(link)})
+	}
+	for _, match := range linkMarkup.FindAllStringSubmatch(text, -1) {
+		add(unescaper.Replace(match[1]), unescaper.Replace(match[2]))
+	}
+	for _, attach := range attachments {
+		if strings.HasPrefix(attach.URL, "http://") || strings.HasPrefix(attach.URL, "https://") {
+			add(attach.URL, attach.Title)
+		}
+	}
+	return links
+}
+
+// LinkResultFromStored creates a LinkResult from a StoredLink
+func LinkResultFromStored(link StoredLink) (LinkResult, error) {
+	timestamp, err := TimestampSeconds(link.Timestamp)
+	if err != nil {
+		return LinkResult{}, err
+	}
+	return LinkResult{
+		URL:       link.URL,
+		Label:     link.Label,
+		Domain:    link.Domain,
+		Channel:   link.Channel,
+		TS:        link.Timestamp,
+		Timestamp: timestamp,
+		User:      link.User,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StoredNotableMessage is read from the db
+// Reactions is the number of reactions to the message, and Replies
+// the number of replies in its thread
+type StoredNotableMessage struct {
+	Message   StoredMessage
+	Reactions int
+	Replies   int
+}
+
+// NotableMessage is returned from the API / to the front end
+// Score ranks messages by their reactions and replies
+type NotableMessage struct {
+	ChannelMessage
+	Year          int `json:"year"`
+	YearsAgo      int `json:"years_ago"`
+	ReactionCount int `json:"reaction_count"`
+	Replies       int `json:"replies"`
+	Score         int `json:"score"`
+}
+
+// SameDay finds the start of the day with the same month and day as date
+// in another year, in date's location. It is false if the year has no such
+// day, which is only the case for February 29th
+func SameDay(date time.Time, year int) (time.Time, bool) {
+	day := time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return day, day.Month() == date.Month()
+}
+
+// NotableMessageFromStored creates a NotableMessage from a StoredNotableMessage
+// found on the same day as date in a previous year
+func NotableMessageFromStored(notable StoredNotableMessage, date time.Time) (NotableMessage, error) {
+	message, err := ChannelMessageFromStored(notable.Message)
+	if err != nil {
+		return NotableMessage{}, err
+	}
+	year := time.Unix(int64(message.Timestamp), 0).In(date.Location()).Year()
+	return NotableMessage{
+		ChannelMessage: message,
+		Year:           year,
+		YearsAgo:       date.Year() - year,
+		ReactionCount:  notable.Reactions,
+		Replies:        notable.Replies,
+		Score:          notable.Reactions + notable.Replies,
+	}, nil
+}
+
+// DigestSubject is the subject of the email digest of messages
+// from the same day as date in previous years
+func DigestSubject(date time.Time) string {
+	return "On this day in the archive: " + date.Format("January 2")
+}
+
+// DigestBody is the plain text of the email digest of messages,
+// linking to them in the archive viewer at viewerURL
+func DigestBody(messages []NotableMessage, viewerURL string) string {
+	var b strings.Builder
+	for i, msg := range messages {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		plural := "s"
+		if msg.YearsAgo == 1 {
+			plural = ""
+		}
+		fmt.Fprintf(&b, "%d year%s ago in #%s, %s wrote (%d reactions, %d replies):\n",
+			msg.YearsAgo, plural, msg.Channel, msg.User, msg.ReactionCount, msg.Replies)
+		for _, line := range strings.Split(msg.Text, "\n") {
+			b.WriteString("> " + line + "\n")
+		}
+		b.WriteString(ViewerLink(viewerURL, msg.Channel, msg.TS, "") + "\n")
+	}
+	return b.String()
+}
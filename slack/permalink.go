@@ -0,0 +1,65 @@
+package slack
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var permalinkPath = regexp.MustCompile(`^/archives/([A-Z0-9]+)/p([0-9]{7,})$`)
+
+// Permalink is a link to a message in Slack
+// ThreadTimestamp is set for replies, when the link has a thread_ts
+type Permalink struct {
+	ChannelID       string
+	Timestamp       string
+	ThreadTimestamp string
+}
+
+// ResolvedPermalink is returned from the API / to the front end
+// ThreadTS is the ts of the thread's parent if the message is a reply
+type ResolvedPermalink struct {
+	Channel   string `json:"channel"`
+	TS        string `json:"ts"`
+	ThreadTS  string `json:"thread_ts"`
+	ViewerURL string `json:"viewer_url"`
+}
+
+// PermalinkTimestamp converts the p1588412758000100 form of a Slack ts
+// used in permalinks back to 1588412758.000100
+func PermalinkTimestamp(digits string) string {
+	return digits[:len(digits)-6] + "." + digits[len(digits)-6:]
+}
+
+// ParsePermalink parses a Slack message link such as
+// https://team.slack.com/archives/C0123/p1588412758000100?thread_ts=1588412700.000200&cid=C0123.
+// Only the path and query matter, so links to any workspace are accepted
+func ParsePermalink(link string) (Permalink, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return Permalink{}, fmt.Errorf("Invalid permalink: %v", err)
+	}
+	match := permalinkPath.FindStringSubmatch(parsed.Path)
+	if match == nil {
+		return Permalink{}, fmt.Errorf("Invalid permalink: %s is not a Slack message link", link)
+	}
+	p := Permalink{ChannelID: match[1], Timestamp: PermalinkTimestamp(match[2])}
+	if threadTS := parsed.Query().Get("thread_ts"); threadTS != "" && threadTS != p.Timestamp {
+		if _, err = TimestampMicros(threadTS); err != nil {
+			return Permalink{}, fmt.Errorf("Invalid permalink thread_ts: %s", threadTS)
+		}
+		p.ThreadTimestamp = threadTS
+	}
+	return p, nil
+}
+
+// ViewerLink links to a message in the archive viewer at viewerURL.
+// threadTS is the thread a reply is in, and empty for other messages
+func ViewerLink(viewerURL, channel, ts, threadTS string) string {
+	link := url.Values{"channel": {channel}, "ts": {ts}}
+	if threadTS != "" && threadTS != ts {
+		link.Set("thread", threadTS)
+	}
+	return strings.TrimSuffix(viewerURL, "/") + "/static/index.html?" + link.Encode()
+}
@@ -0,0 +1,82 @@
+package slack
+
+import "testing"
+
+func TestParsePermalink(t *testing.T) {
+	tests := []struct {
+		link string
+		want Permalink
+	}{
+		{
+			link: "https://team.slack.com/archives/C0123/p1588412758000100",
+			want: Permalink{ChannelID: "C0123", Timestamp: "1588412758.000100"},
+		},
+		{
+			link: "https://other.slack.com/archives/C0123/p1588412758000100?thread_ts=1588412700.000200&cid=C0123",
+			want: Permalink{ChannelID: "C0123", Timestamp: "1588412758.000100", ThreadTimestamp: "1588412700.000200"},
+		},
+		{
+			// Links to a thread's parent may give its own ts as the thread_ts
+			link: "https://team.slack.com/archives/G0456/p1588412700000200?thread_ts=1588412700.000200",
+			want: Permalink{ChannelID: "G0456", Timestamp: "1588412700.000200"},
+		},
+		{
+			link: "/archives/D0789/p1234567",
+			want: Permalink{ChannelID: "D0789", Timestamp: "1.234567"},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParsePermalink(test.link)
+		if err != nil {
+			t.Errorf("ParsePermalink(%q) returned error: %v", test.link, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParsePermalink(%q) = %+v, want %+v", test.link, got, test.want)
+		}
+	}
+}
+
+func TestParsePermalinkInvalid(t *testing.T) {
+	for _, link := range []string{
+		"",
+		"https://team.slack.com/archives/C0123",
+		"https://team.slack.com/archives/C0123/p123456",
+		"https://team.slack.com/archives/c0123/p1588412758000100",
+		"https://team.slack.com/archives/C0123/p1588412758000100/extra",
+		"https://team.slack.com/messages/C0123/p1588412758000100",
+		"https://team.slack.com/archives/C0123/p1588412758000100?thread_ts=yesterday",
+		"%zz",
+	} {
+		if got, err := ParsePermalink(link); err == nil {
+			t.Errorf("ParsePermalink(%q) = %+v, want error", link, got)
+		}
+	}
+}
+
+func TestViewerLink(t *testing.T) {
+	tests := []struct {
+		viewerURL, channel, ts, threadTS string
+		want                             string
+	}{
+		{
+			"http://localhost:8080", "general", "1588412758.000100", "",
+			"http://localhost:8080/static/index.html?channel=general&ts=1588412758.000100",
+		},
+		{
+			"https://archive.example.com/", "dev ops", "1588412758.000100", "1588412700.000200",
+			"https://archive.example.com/static/index.html?channel=dev+ops&thread=1588412700.000200&ts=1588412758.000100",
+		},
+		{
+			"http://localhost:8080", "general", "1588412700.000200", "1588412700.000200",
+			"http://localhost:8080/static/index.html?channel=general&ts=1588412700.000200",
+		},
+	}
+	for _, test := range tests {
+		got := ViewerLink(test.viewerURL, test.channel, test.ts, test.threadTS)
+		if got != test.want {
+			t.Errorf("ViewerLink(%q, %q, %q, %q) = %q, want %q",
+				test.viewerURL, test.channel, test.ts, test.threadTS, got, test.want)
+		}
+	}
+}
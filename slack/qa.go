@@ -0,0 +1,268 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// How the answer to a question was chosen
+const (
+	AnswerAccepted   = "accepted"
+	AnswerReactions  = "reactions"
+	AnswerFirstReply = "first_reply"
+)
+
+// maxTitleLength is the most characters of a question used as its title
+const maxTitleLength = 80
+
+// acceptedEmoji are the reactions the person asking a question
+// marks the reply that answered it with
+var acceptedEmoji = toSet([]string{"white_check_mark", "heavy_check_mark", "ballot_box_with_check"})
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// QAEntry is a thread exported to a knowledge base as a question
+// and its answers. AnswerBy says how the answers were chosen
+type QAEntry struct {
+	Channel      string      `json:"channel"`
+	TS           string      `json:"ts"`
+	Title        string      `json:"title"`
+	Asked        string      `json:"asked"`
+	Question     QAMessage   `json:"question"`
+	Answers      []QAMessage `json:"answers"`
+	AnswerBy     string      `json:"answer_by"`
+	ReplyCount   int         `json:"reply_count"`
+	Participants []string    `json:"participants"`
+	URL          string      `json:"url"`
+}
+
+// QAMessage is the question or an answer in a QAEntry
+type QAMessage struct {
+	TS            string `json:"ts"`
+	Timestamp     uint64 `json:"timestamp"`
+	User          string `json:"user"`
+	Text          string `json:"text"`
+	ReactionCount int    `json:"reaction_count"`
+	URL           string `json:"url"`
+}
+
+// accepted checks whether user marked a reply as answering their question
+func accepted(reply ThreadMessage, user string) bool {
+	for _, reaction := range reply.Reactions {
+		if acceptedEmoji[reaction.Emoji] {
+			for _, reactor := range reaction.Users {
+				if reactor == user {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func reactionCount(reactions []Reaction) int {
+	count := 0
+	for _, reaction := range reactions {
+		count += reaction.Count
+	}
+	return count
+}
+
+// chooseAnswers picks the replies that answer a question: those the person
+// who asked marked with a check mark, or else those with the most reactions,
+// or else the first reply from someone else. Replies from the person who
+// asked only count if they marked them
+func chooseAnswers(asker string, replies []ThreadMessage) ([]ThreadMessage, string) {
+	var answers []ThreadMessage
+	for _, reply := range replies {
+		if accepted(reply, asker) {
+			answers = append(answers, reply)
+		}
+	}
+	if len(answers) > 0 {
+		return answers, AnswerAccepted
+	}
+	most := 0
+	for _, reply := range replies {
+		if count := reactionCount(reply.Reactions); reply.User != asker && count > most {
+			most = count
+		}
+	}
+	for _, reply := range replies {
+		if most > 0 && reply.User != asker && reactionCount(reply.Reactions) == most {
+			answers = append(answers, reply)
+		}
+	}
+	if len(answers) > 0 {
+		return answers, AnswerReactions
+	}
+	for _, reply := range replies {
+		if reply.User != asker {
+			return []ThreadMessage{reply}, AnswerFirstReply
+		}
+	}
+	return nil, ""
+}
+
+// QAEntryFromThread makes a knowledge base entry of a thread in channel,
+// linking to it in the archive viewer at viewerURL. It is false if nobody
+// but the person who asked replied, so there is no answer
+func QAEntryFromThread(
+	channel string, parent ParentMessage, replies []ThreadMessage, viewerURL string,
+) (QAEntry, bool) {
+	answers, answerBy := chooseAnswers(parent.User, replies)
+	if len(answers) == 0 {
+		return QAEntry{}, false
+	}
+	entry := QAEntry{
+		Channel: channel,
+		TS:      parent.TS,
+		Title:   questionTitle(parent.Text),
+		Asked:   time.Unix(int64(parent.Timestamp), 0).UTC().Format(time.RFC3339),
+		Question: QAMessage{
+			TS:            parent.TS,
+			Timestamp:     parent.Timestamp,
+			User:          parent.User,
+			Text:          parent.Text,
+			ReactionCount: reactionCount(parent.Reactions),
+			URL:           ViewerLink(viewerURL, channel, parent.TS, ""),
+		},
+		Answers:      make([]QAMessage, len(answers)),
+		AnswerBy:     answerBy,
+		ReplyCount:   len(replies),
+		Participants: []string{},
+		URL:          ViewerLink(viewerURL, channel, parent.TS, ""),
+	}
+	for i, answer := range answers {
+		entry.Answers[i] = QAMessage{
+			TS:            answer.TS,
+			Timestamp:     answer.Timestamp,
+			User:          answer.User,
+			Text:          answer.Text,
+			ReactionCount: reactionCount(answer.Reactions),
+			URL:           ViewerLink(viewerURL, channel, answer.TS, parent.TS),
+		}
+	}
+	seen := map[string]bool{parent.User: true}
+	for _, reply := range replies {
+		if !seen[reply.User] {
+			seen[reply.User] = true
+			entry.Participants = append(entry.Participants, reply.User)
+		}
+	}
+	return entry, true
+}
+
+// plainText turns Slack's link markup in message text into the link's
+// label, or the link itself if it has none, and unescapes the text
+func plainText(text string) string {
+	text = linkMarkup.ReplaceAllStringFunc(text, func(link string) string {
+		match := linkMarkup.FindStringSubmatch(link)
+		if match[2] == "" {
+			return match[1]
+		}
+		return match[2]
+	})
+	return unescaper.Replace(labelledLink.ReplaceAllString(text, "$1"))
+}
+
+// markdownText turns Slack's link markup in message text into Markdown.
+// Slack escapes text the same way as HTML, which Markdown renders as is
+func markdownText(text string) string {
+	return linkMarkup.ReplaceAllStringFunc(text, func(link string) string {
+		match := linkMarkup.FindStringSubmatch(link)
+		if match[2] == "" {
+			return "<" + match[1] + ">"
+		}
+		return "[" + match[2] + "](" + match[1] + ")"
+	})
+}
+
+// MarkdownEscape escapes plain text such as a title for Markdown
+func MarkdownEscape(text string) string {
+	return escaper.Replace(text)
+}
+
+// questionTitle is the first sentence of a question, shortened to
+// maxTitleLength characters at a word boundary
+func questionTitle(text string) string {
+	sentences := Sentences(plainText(text))
+	if len(sentences) == 0 {
+		return "Untitled question"
+	}
+	title := strings.Join(strings.Fields(sentences[0]), " ")
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength])
+		if space := strings.LastIndex(title, " "); space > 0 {
+			title = title[:space]
+		}
+		title += "…"
+	}
+	return title
+}
+
+// QASlug makes a file name for an entry from the date and words of its title
+func QASlug(entry QAEntry) string {
+	words := Words(entry.Title)
+	if len(words) > 8 {
+		words = words[:8]
+	}
+	slug := strings.Join(words, "-")
+	if slug == "" {
+		slug = "question"
+	}
+	return entry.Asked[:len("2006-01-02")] + "-" + slug
+}
+
+// WriteQAMarkdown writes an entry as Markdown, with its metadata
+// as YAML front matter for wikis and static site generators
+func WriteQAMarkdown(w io.Writer, entry QAEntry) error {
+	front := []struct {
+		key   string
+		value interface{}
+	}{
+		{"title", entry.Title},
+		{"channel", entry.Channel},
+		{"ts", entry.TS},
+		{"asked", entry.Asked},
+		{"asked_by", entry.Question.User},
+		{"answer_by", entry.AnswerBy},
+		{"reply_count", entry.ReplyCount},
+		{"participants", entry.Participants},
+		{"url", entry.URL},
+	}
+	var b strings.Builder
+	b.WriteString("---\n")
+	// JSON values are valid YAML, and quote anything that needs it
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	for _, field := range front {
+		b.WriteString(field.key + ": ")
+		if err := encoder.Encode(field.value); err != nil {
+			return err
+		}
+	}
+	b.WriteString("---\n\n")
+	fmt.Fprintf(&b, "# %s\n\n## Question\n\n%s\n\n", MarkdownEscape(entry.Title), markdownText(entry.Question.Text))
+	fmt.Fprintf(&b, "*Asked by %s in #%s on %s.* [View in archive](%s)\n",
+		entry.Question.User, entry.Channel, entry.Asked[:len("2006-01-02")], entry.Question.URL)
+	heading := "Answer"
+	if len(entry.Answers) > 1 {
+		heading = "Answers"
+	}
+	fmt.Fprintf(&b, "\n## %s\n", heading)
+	for _, answer := range entry.Answers {
+		fmt.Fprintf(&b, "\n%s\n\n*Answered by %s", markdownText(answer.Text), answer.User)
+		if answer.ReactionCount == 1 {
+			b.WriteString(", 1 reaction")
+		} else if answer.ReactionCount > 1 {
+			fmt.Fprintf(&b, ", %d reactions", answer.ReactionCount)
+		}
+		fmt.Fprintf(&b, ".* [View in archive](%s)\n", answer.URL)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
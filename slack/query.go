@@ -0,0 +1,173 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// dateLayout is the format of dates in search modifiers
+const dateLayout = "2006-01-02"
+
+// SearchQuery is a parsed Slack-style search query
+// Terms, phrases and exclusions are matched against message text,
+// while the modifiers narrow down which messages are searched
+type SearchQuery struct {
+	Terms    []string
+	Phrases  []string
+	Excluded []string
+	From     []string
+	In       []string
+	// Languages are the language hints of code blocks, from lang:
+	Languages []string
+	// After and Before bound the search to [After, Before)
+	After    time.Time
+	Before   time.Time
+	HasLink  bool
+	HasFile  bool
+	IsThread bool
+}
+
+// HasModifiersOnly reports whether the query has nothing but modifiers,
+// with no text to match or exclude
+func (q SearchQuery) HasModifiersOnly() bool {
+	return !q.HasText() && len(q.Excluded) == 0
+}
+
+// HasText reports whether the query matches against message text at all,
+// rather than only filtering by modifiers
+func (q SearchQuery) HasText() bool {
+	return len(q.Terms) > 0 || len(q.Phrases) > 0
+}
+
+// ParseSearchQuery parses a query such as
+// `deploy "stack trace" -staging from:@alice in:#ops after:2020-05-01 has:link lang:bash`.
+// Dates are interpreted in loc
+func ParseSearchQuery(query string, loc *time.Location) (SearchQuery, error) {
+	var q SearchQuery
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return SearchQuery{}, err
+	}
+	for _, token := range tokens {
+		if token.excluded {
+			if token.value != "" {
+				q.Excluded = append(q.Excluded, token.value)
+			}
+			continue
+		}
+		if token.quoted {
+			q.Phrases = append(q.Phrases, token.value)
+			continue
+		}
+		key, value := "", token.value
+		if i := strings.Index(token.value, ":"); i > 0 && token.modifier {
+			key, value = strings.ToLower(token.value[:i]), token.value[i+1:]
+		}
+		switch key {
+		case "":
+			q.Terms = append(q.Terms, value)
+		case "from":
+			q.From = append(q.From, strings.TrimPrefix(value, "@"))
+		case "in":
+			q.In = append(q.In, strings.TrimPrefix(value, "#"))
+		case "lang":
+			q.Languages = append(q.Languages, strings.ToLower(value))
+		case "before", "after", "on":
+			day, err := time.ParseInLocation(dateLayout, value, loc)
+			if err != nil {
+				return SearchQuery{}, fmt.Errorf("Invalid %s: date must look like %s", key, dateLayout)
+			}
+			switch key {
+			case "before":
+				q.Before = laterOf(q.Before, day, false)
+			case "after":
+				q.After = laterOf(q.After, day.AddDate(0, 0, 1), true)
+			case "on":
+				q.After = laterOf(q.After, day, true)
+				q.Before = laterOf(q.Before, day.AddDate(0, 0, 1), false)
+			}
+		case "has":
+			switch strings.ToLower(value) {
+			case "link":
+				q.HasLink = true
+			case "file":
+				q.HasFile = true
+			default:
+				return SearchQuery{}, fmt.Errorf("Invalid has: %s", value)
+			}
+		case "is":
+			if strings.ToLower(value) != "thread" {
+				return SearchQuery{}, fmt.Errorf("Invalid is: %s", value)
+			}
+			q.IsThread = true
+		default:
+			q.Terms = append(q.Terms, token.value)
+		}
+	}
+	return q, nil
+}
+
+// laterOf narrows a bound, keeping the later of two lower bounds
+// or the earlier of two upper bounds
+func laterOf(bound, candidate time.Time, lower bool) time.Time {
+	if bound.IsZero() || (lower && candidate.After(bound)) || (!lower && candidate.Before(bound)) {
+		return candidate
+	}
+	return bound
+}
+
+type queryToken struct {
+	value    string
+	quoted   bool
+	excluded bool
+	// modifier is set when the token may be a key:value modifier
+	modifier bool
+}
+
+// tokenizeQuery splits a query on whitespace, keeping quoted text together.
+// Quotes may also surround a modifier's value, as in from:"Alice Smith"
+func tokenizeQuery(query string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0, 8)
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		token := queryToken{modifier: true}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			token.excluded = true
+			token.modifier = false
+			i++
+		}
+		var value strings.Builder
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			if runes[i] != '"' {
+				value.WriteRune(runes[i])
+				i++
+				continue
+			}
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("Unterminated quote in query")
+			}
+			if value.Len() == 0 {
+				token.quoted = true
+				token.modifier = false
+			}
+			value.WriteString(string(runes[i+1 : end]))
+			i = end + 1
+		}
+		token.value = value.String()
+		if !strings.Contains(token.value, ":") {
+			token.modifier = false
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,119 @@
+package slack
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		query string
+		want  SearchQuery
+	}{
+		{
+			query: `deploy "stack trace" -staging from:@alice in:#ops has:link lang:Bash`,
+			want: SearchQuery{
+				Terms:     []string{"deploy"},
+				Phrases:   []string{"stack trace"},
+				Excluded:  []string{"staging"},
+				From:      []string{"alice"},
+				In:        []string{"ops"},
+				Languages: []string{"bash"},
+				HasLink:   true,
+			},
+		},
+		{
+			query: `from:"Alice Smith" -"dry run" HAS:File is:Thread`,
+			want: SearchQuery{
+				Excluded: []string{"dry run"},
+				From:     []string{"Alice Smith"},
+				HasFile:  true,
+				IsThread: true,
+			},
+		},
+		{
+			query: `see https://example.com/a:b notes:`,
+			want:  SearchQuery{Terms: []string{"see", "https://example.com/a:b", "notes:"}},
+		},
+		{
+			query: `  rollback   -  `,
+			want:  SearchQuery{Terms: []string{"rollback", "-"}},
+		},
+		{
+			query: `after:2020-05-01 before:2020-05-10`,
+			want: SearchQuery{
+				After:  time.Date(2020, 5, 2, 0, 0, 0, 0, utc),
+				Before: time.Date(2020, 5, 10, 0, 0, 0, 0, utc),
+			},
+		},
+		{
+			query: `on:2020-05-01`,
+			want: SearchQuery{
+				After:  time.Date(2020, 5, 1, 0, 0, 0, 0, utc),
+				Before: time.Date(2020, 5, 2, 0, 0, 0, 0, utc),
+			},
+		},
+		{
+			query: `after:2020-05-01 after:2020-05-03 before:2020-05-20 before:2020-05-10 on:2020-05-08`,
+			want: SearchQuery{
+				After:  time.Date(2020, 5, 8, 0, 0, 0, 0, utc),
+				Before: time.Date(2020, 5, 9, 0, 0, 0, 0, utc),
+			},
+		},
+		{
+			query: ``,
+			want:  SearchQuery{},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseSearchQuery(test.query, utc)
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%q) returned error: %v", test.query, err)
+			continue
+		}
+		if !got.After.Equal(test.want.After) || !got.Before.Equal(test.want.Before) {
+			t.Errorf("ParseSearchQuery(%q) bounds = [%v, %v), want [%v, %v)",
+				test.query, got.After, got.Before, test.want.After, test.want.Before)
+		}
+		got.After, got.Before = time.Time{}, time.Time{}
+		test.want.After, test.want.Before = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseSearchQuery(%q) = %+v, want %+v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseSearchQueryLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	got, err := ParseSearchQuery("on:2020-03-08", loc)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery returned error: %v", err)
+	}
+	// The clocks went forward that day, so it was 23 hours long
+	wantAfter := time.Date(2020, 3, 8, 5, 0, 0, 0, time.UTC)
+	wantBefore := time.Date(2020, 3, 9, 4, 0, 0, 0, time.UTC)
+	if !got.After.Equal(wantAfter) || !got.Before.Equal(wantBefore) {
+		t.Errorf("ParseSearchQuery bounds = [%v, %v), want [%v, %v)", got.After, got.Before, wantAfter, wantBefore)
+	}
+}
+
+func TestParseSearchQueryInvalid(t *testing.T) {
+	for _, query := range []string{
+		`"stack trace`,
+		`from:"Alice`,
+		`has:picture`,
+		`is:dm`,
+		`before:yesterday`,
+		`after:2020-13-01`,
+		`on:05/01/2020`,
+	} {
+		if got, err := ParseSearchQuery(query, time.UTC); err == nil {
+			t.Errorf("ParseSearchQuery(%q) = %+v, want error", query, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package slack
+
+import (
+	"sort"
+	"strings"
+)
+
+// skinToneSeparator separates an emoji name from its skin tone,
+// as in "+1::skin-tone-2"
+const skinToneSeparator = "::"
+
+// BaseEmoji strips any skin tone from an emoji name
+func BaseEmoji(name string) string {
+	return strings.SplitN(name, skinToneSeparator, 2)[0]
+}
+
+// GroupReactions combines reacts with the same emoji but different skin tones,
+// keeping the order in which each emoji was first used
+func GroupReactions(reacts []React) []Reaction {
+	if len(reacts) == 0 {
+		return nil
+	}
+	reactions := make([]Reaction, 0, len(reacts))
+	indices := make(map[string]int, len(reacts))
+	seen := make(map[string]map[string]bool, len(reacts))
+	for _, react := range reacts {
+		emoji := BaseEmoji(react.Name)
+		i, ok := indices[emoji]
+		if !ok {
+			i = len(reactions)
+			indices[emoji] = i
+			seen[emoji] = make(map[string]bool)
+			reactions = append(reactions, Reaction{Emoji: emoji, Users: make([]string, 0, len(react.Users))})
+		}
+		reactions[i].Count += react.Count
+		reactions[i].Variants = append(reactions[i].Variants, react.Name)
+		for _, user := range react.Users {
+			if !seen[emoji][user] {
+				seen[emoji][user] = true
+				reactions[i].Users = append(reactions[i].Users, user)
+			}
+		}
+	}
+	return reactions
+}
+
+// ReactsFromReactions flattens reactions into a map from emoji to users
+func ReactsFromReactions(reactions []Reaction) map[string][]string {
+	if len(reactions) == 0 {
+		return nil
+	}
+	reacts := make(map[string][]string, len(reactions))
+	for _, reaction := range reactions {
+		reacts[reaction.Emoji] = reaction.Users
+	}
+	return reacts
+}
+
+// legacyReactions converts reacts stored as a map from emoji to user names,
+// as they were before reactions were stored with user IDs and counts
+func legacyReactions(reacts map[string][]string) []Reaction {
+	if len(reacts) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(reacts))
+	for name := range reacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	grouped := make([]React, len(names))
+	for i, name := range names {
+		grouped[i] = React{Name: name, Users: reacts[name], Count: len(reacts[name])}
+	}
+	return GroupReactions(grouped)
+}
@@ -0,0 +1,140 @@
+package slack
+
+import (
+	"sort"
+	"time"
+)
+
+// ThreadReplies is read from the db
+// Replies are the replies from users other than the one who started
+// the thread, oldest first
+type ThreadReplies struct {
+	Channel      string
+	ParentMicros int64
+	Replies      []ReplyTime
+}
+
+// ReplyTime is read from the db
+type ReplyTime struct {
+	User   string
+	Micros int64
+}
+
+// ResponseStats is returned from the API / to the front end
+type ResponseStats struct {
+	Channels   []ChannelResponseStats `json:"channels"`
+	Responders []ResponderStats       `json:"responders"`
+}
+
+// ChannelResponseStats is returned from the API / to the front end
+// Times are in seconds after the message that started the thread
+type ChannelResponseStats struct {
+	Channel          string  `json:"channel"`
+	Threads          int     `json:"threads"`
+	MedianFirstReply float64 `json:"median_first_reply"`
+	MeanFirstReply   float64 `json:"mean_first_reply"`
+	MedianLastReply  float64 `json:"median_last_reply"`
+	MeanLastReply    float64 `json:"mean_last_reply"`
+}
+
+// ResponderStats is returned from the API / to the front end
+// Response times are in seconds from the message that started a thread
+// to the user's first reply in it
+type ResponderStats struct {
+	User           string  `json:"user"`
+	Threads        int     `json:"threads"`
+	FirstReplies   int     `json:"first_replies"`
+	MedianResponse float64 `json:"median_response"`
+	MeanResponse   float64 `json:"mean_response"`
+}
+
+// median finds the middle of durations, sorting them in place
+func median(durations []float64) float64 {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Float64s(durations)
+	middle := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[middle-1] + durations[middle]) / 2
+	}
+	return durations[middle]
+}
+
+func mean(durations []float64) float64 {
+	if len(durations) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, d := range durations {
+		sum += d
+	}
+	return sum / float64(len(durations))
+}
+
+// ResponseTimes works out how long threads took to get their first and last
+// replies per channel, and how quickly each user replied to threads,
+// leaving out threads without replies. Channels are in the order of threads,
+// and responders are sorted by the number of threads they replied to
+func ResponseTimes(threads []ThreadReplies) ResponseStats {
+	stats := ResponseStats{
+		Channels:   make([]ChannelResponseStats, 0, 16),
+		Responders: make([]ResponderStats, 0, 64),
+	}
+	seconds := func(micros int64) float64 {
+		return float64(micros) / float64(time.Second/time.Microsecond)
+	}
+	channels := make(map[string]int)
+	var first, last [][]float64
+	responders := make(map[string]int)
+	var responses [][]float64
+	for _, thread := range threads {
+		if len(thread.Replies) == 0 {
+			continue
+		}
+		c, ok := channels[thread.Channel]
+		if !ok {
+			c = len(stats.Channels)
+			channels[thread.Channel] = c
+			stats.Channels = append(stats.Channels, ChannelResponseStats{Channel: thread.Channel})
+			first, last = append(first, nil), append(last, nil)
+		}
+		stats.Channels[c].Threads++
+		first[c] = append(first[c], seconds(thread.Replies[0].Micros-thread.ParentMicros))
+		last[c] = append(last[c], seconds(thread.Replies[len(thread.Replies)-1].Micros-thread.ParentMicros))
+
+		replied := make(map[string]bool)
+		for i, reply := range thread.Replies {
+			if replied[reply.User] {
+				continue
+			}
+			replied[reply.User] = true
+			r, ok := responders[reply.User]
+			if !ok {
+				r = len(stats.Responders)
+				responders[reply.User] = r
+				stats.Responders = append(stats.Responders, ResponderStats{User: reply.User})
+				responses = append(responses, nil)
+			}
+			stats.Responders[r].Threads++
+			if i == 0 {
+				stats.Responders[r].FirstReplies++
+			}
+			responses[r] = append(responses[r], seconds(reply.Micros-thread.ParentMicros))
+		}
+	}
+	for c := range stats.Channels {
+		stats.Channels[c].MeanFirstReply = mean(first[c])
+		stats.Channels[c].MedianFirstReply = median(first[c])
+		stats.Channels[c].MeanLastReply = mean(last[c])
+		stats.Channels[c].MedianLastReply = median(last[c])
+	}
+	for r := range stats.Responders {
+		stats.Responders[r].MeanResponse = mean(responses[r])
+		stats.Responders[r].MedianResponse = median(responses[r])
+	}
+	sort.SliceStable(stats.Responders, func(i, j int) bool {
+		return stats.Responders[i].Threads > stats.Responders[j].Threads
+	})
+	return stats
+}
@@ -0,0 +1,221 @@
+package slack
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Intervals that message counts can be grouped by
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// StatsQuery narrows down the messages statistics are gathered from
+// From and To bound them in microseconds, From inclusively and To
+// exclusively unless it is 0. Channels are every channel if empty
+type StatsQuery struct {
+	Channels []string
+	From, To int64
+}
+
+// ChannelStats is returned from the API / to the front end
+// ThreadRatio is the fraction of parent messages that started a thread
+type ChannelStats struct {
+	Channel       string  `json:"channel"`
+	Messages      int     `json:"messages"`
+	Replies       int     `json:"replies"`
+	Threads       int     `json:"threads"`
+	ThreadRatio   float64 `json:"thread_ratio"`
+	Users         int     `json:"users"`
+	FirstActivity uint64  `json:"first_activity"`
+	LastActivity  uint64  `json:"last_activity"`
+}
+
+// UserStats is returned from the API / to the front end
+type UserStats struct {
+	User           string `json:"user"`
+	Messages       int    `json:"messages"`
+	Replies        int    `json:"replies"`
+	ThreadsStarted int    `json:"threads_started"`
+	Channels       int    `json:"channels"`
+	FirstActivity  uint64 `json:"first_activity"`
+	LastActivity   uint64 `json:"last_activity"`
+}
+
+// QuarterHour is the length in microseconds of the slots messages are
+// counted in by the db before they are counted by periods of local time.
+// Time zones are a whole number of quarter hours from UTC, so each slot
+// falls in a single hour of local time
+const QuarterHour int64 = 15 * 60 * 1e6
+
+// TimeCount is read from the db
+// Messages were sent in the quarter hour starting at Micros
+type TimeCount struct {
+	Micros   int64
+	Messages int
+}
+
+// ActivityBucket is returned from the API / to the front end
+// Period is the date the bucket starts on, or the month for monthly buckets
+type ActivityBucket struct {
+	Period   string `json:"period"`
+	Start    uint64 `json:"start"`
+	Messages int    `json:"messages"`
+}
+
+// HourStats is returned from the API / to the front end
+// Hours and WeekdayHours count messages by hour of the day,
+// and WeekdayHours by day of the week too, starting from Sunday
+type HourStats struct {
+	Hours        [24]int     `json:"hours"`
+	WeekdayHours [7][24]int  `json:"weekday_hours"`
+	Busiest      []HourCount `json:"busiest"`
+}
+
+// HourCount is returned from the API / to the front end
+type HourCount struct {
+	Hour     int `json:"hour"`
+	Messages int `json:"messages"`
+}
+
+// CalendarDay is returned from the API / to the front end
+// Hours is only filled in when asked for
+type CalendarDay struct {
+	Date     string   `json:"date"`
+	Start    uint64   `json:"start"`
+	Messages int      `json:"messages"`
+	Hours    *[24]int `json:"hours,omitempty"`
+}
+
+// periodStart finds the start of the interval containing t, in t's location.
+// Weeks start on Monday
+func periodStart(t time.Time, interval string) time.Time {
+	year, month, day := t.Date()
+	switch interval {
+	case IntervalWeek:
+		day -= (int(t.Weekday()) + 6) % 7
+	case IntervalMonth:
+		day = 1
+	}
+	return dayStart(year, month, day, t.Location())
+}
+
+// dayStart finds when a day starts in loc. That is midnight, unless DST
+// skips it, in which case the day starts when the clocks go forward
+func dayStart(year int, month time.Month, day int, loc *time.Location) time.Time {
+	start := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	noon := time.Date(year, month, day, 12, 0, 0, 0, loc)
+	if start.Day() != noon.Day() {
+		// A skipped midnight is given in the offset from after the change
+		_, before := start.Zone()
+		_, after := noon.Zone()
+		start = start.Add(time.Duration(after-before) * time.Second)
+	}
+	return start
+}
+
+// nextPeriod finds the start of the interval after the one starting at
+// start. It goes by the date, since a period doesn't start at midnight
+// where DST skips it, so adding a day to its start can end up an hour off
+func nextPeriod(start time.Time, interval string) time.Time {
+	year, month, day := start.Date()
+	switch interval {
+	case IntervalWeek:
+		day += 7
+	case IntervalMonth:
+		month++
+	default:
+		day++
+	}
+	return dayStart(year, month, day, start.Location())
+}
+
+// ActivityBuckets counts messages, given by how many were sent in each
+// quarter hour, per day, week or month in loc. Periods without messages
+// between the first and last message are included with a count of 0
+func ActivityBuckets(times []TimeCount, interval string, loc *time.Location) ([]ActivityBucket, error) {
+	if interval != IntervalDay && interval != IntervalWeek && interval != IntervalMonth {
+		return nil, fmt.Errorf("Invalid interval: %s", interval)
+	}
+	if len(times) == 0 {
+		return []ActivityBucket{}, nil
+	}
+	counts := make(map[int64]int)
+	var first, last time.Time
+	for i, count := range times {
+		start := periodStart(time.Unix(0, count.Micros*1e3).In(loc), interval)
+		counts[start.Unix()] += count.Messages
+		if i == 0 || start.Before(first) {
+			first = start
+		}
+		if i == 0 || start.After(last) {
+			last = start
+		}
+	}
+	layout := "2006-01-02"
+	if interval == IntervalMonth {
+		layout = "2006-01"
+	}
+	buckets := make([]ActivityBucket, 0, len(counts))
+	for start := first; !start.After(last); start = nextPeriod(start, interval) {
+		buckets = append(buckets, ActivityBucket{
+			Period:   start.Format(layout),
+			Start:    uint64(start.Unix()),
+			Messages: counts[start.Unix()],
+		})
+	}
+	return buckets, nil
+}
+
+// HourBuckets counts messages, given by how many were sent in each
+// quarter hour, by hour of the day and day of the week in loc
+func HourBuckets(times []TimeCount, loc *time.Location) HourStats {
+	var stats HourStats
+	for _, count := range times {
+		t := time.Unix(0, count.Micros*1e3).In(loc)
+		stats.Hours[t.Hour()] += count.Messages
+		stats.WeekdayHours[t.Weekday()][t.Hour()] += count.Messages
+	}
+	stats.Busiest = make([]HourCount, 0, 24)
+	for hour, count := range stats.Hours {
+		if count > 0 {
+			stats.Busiest = append(stats.Busiest, HourCount{Hour: hour, Messages: count})
+		}
+	}
+	sort.SliceStable(stats.Busiest, func(i, j int) bool {
+		return stats.Busiest[i].Messages > stats.Busiest[j].Messages
+	})
+	return stats
+}
+
+// CalendarDays counts messages, given by how many were sent in each
+// quarter hour, per day in loc, and per hour of each day if hours is set.
+// Only days with messages are included, in chronological order
+func CalendarDays(times []TimeCount, loc *time.Location, hours bool) []CalendarDay {
+	days := make([]CalendarDay, 0)
+	index := make(map[int64]int)
+	for _, count := range times {
+		t := time.Unix(0, count.Micros*1e3).In(loc)
+		start := periodStart(t, IntervalDay)
+		i, ok := index[start.Unix()]
+		if !ok {
+			i = len(days)
+			index[start.Unix()] = i
+			days = append(days, CalendarDay{Date: start.Format("2006-01-02"), Start: uint64(start.Unix())})
+			if hours {
+				days[i].Hours = &[24]int{}
+			}
+		}
+		days[i].Messages += count.Messages
+		if hours {
+			days[i].Hours[t.Hour()] += count.Messages
+		}
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Start < days[j].Start
+	})
+	return days
+}
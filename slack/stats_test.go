@@ -0,0 +1,174 @@
+package slack
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// santiago skips midnight when its clocks go forward,
+// and repeats the hour before it when they go back
+func santiago(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	return loc
+}
+
+func TestDayStart(t *testing.T) {
+	loc := santiago(t)
+	tests := []struct {
+		year  int
+		month time.Month
+		day   int
+		want  int64
+	}{
+		{2019, 9, 7, 1567828800},
+		// Midnight is skipped, so the day starts at 01:00 -03
+		{2019, 9, 8, 1567915200},
+		{2019, 9, 9, 1567998000},
+		{2020, 4, 4, 1585969200},
+		// The hour before midnight is repeated, so the day before is 25 hours long
+		{2020, 4, 5, 1586059200},
+	}
+	for _, test := range tests {
+		got := dayStart(test.year, test.month, test.day, loc)
+		if got.Unix() != test.want {
+			t.Errorf("dayStart(%d, %d, %d) = %v (%d), want %d",
+				test.year, test.month, test.day, got, got.Unix(), test.want)
+		}
+	}
+}
+
+func TestNextPeriod(t *testing.T) {
+	loc := santiago(t)
+	tests := []struct {
+		start    int64
+		interval string
+		want     int64
+	}{
+		{1567828800, IntervalDay, 1567915200},
+		{1567915200, IntervalDay, 1567998000},
+		{1585969200, IntervalDay, 1586059200},
+		{1567396800, IntervalWeek, 1567998000},
+		{1567310400, IntervalMonth, 1569898800},
+	}
+	for _, test := range tests {
+		got := nextPeriod(time.Unix(test.start, 0).In(loc), test.interval)
+		if got.Unix() != test.want {
+			t.Errorf("nextPeriod(%d, %s) = %v (%d), want %d", test.start, test.interval, got, got.Unix(), test.want)
+		}
+	}
+}
+
+func TestActivityBuckets(t *testing.T) {
+	loc := santiago(t)
+	times := []TimeCount{
+		{Micros: 1567785600 * 1e6, Messages: 1}, // 2019-09-06 12:00 -04
+		{Micros: 1567913400 * 1e6, Messages: 2}, // 2019-09-07 23:30 -04
+		{Micros: 1567915200 * 1e6, Messages: 3}, // 2019-09-08 01:00 -03
+		{Micros: 1567954800 * 1e6, Messages: 4}, // 2019-09-08 12:00 -03
+		{Micros: 1568088000 * 1e6, Messages: 5}, // 2019-09-10 01:00 -03
+	}
+	tests := []struct {
+		interval string
+		want     []ActivityBucket
+	}{
+		{IntervalDay, []ActivityBucket{
+			{Period: "2019-09-06", Start: 1567742400, Messages: 1},
+			{Period: "2019-09-07", Start: 1567828800, Messages: 2},
+			{Period: "2019-09-08", Start: 1567915200, Messages: 7},
+			{Period: "2019-09-09", Start: 1567998000, Messages: 0},
+			{Period: "2019-09-10", Start: 1568084400, Messages: 5},
+		}},
+		{IntervalWeek, []ActivityBucket{
+			{Period: "2019-09-02", Start: 1567396800, Messages: 10},
+			{Period: "2019-09-09", Start: 1567998000, Messages: 5},
+		}},
+		{IntervalMonth, []ActivityBucket{
+			{Period: "2019-09", Start: 1567310400, Messages: 15},
+		}},
+	}
+	for _, test := range tests {
+		got, err := ActivityBuckets(times, test.interval, loc)
+		if err != nil {
+			t.Errorf("ActivityBuckets(%s) returned error: %v", test.interval, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ActivityBuckets(%s) = %+v, want %+v", test.interval, got, test.want)
+		}
+	}
+}
+
+func TestActivityBucketsRepeatedHour(t *testing.T) {
+	loc := santiago(t)
+	times := []TimeCount{
+		{Micros: 1586052000 * 1e6, Messages: 1}, // 2020-04-04 23:00 -03
+		{Micros: 1586057400 * 1e6, Messages: 2}, // 2020-04-04 23:30 -04, the second time round
+		{Micros: 1586059200 * 1e6, Messages: 3}, // 2020-04-05 00:00 -04
+	}
+	want := []ActivityBucket{
+		{Period: "2020-04-04", Start: 1585969200, Messages: 3},
+		{Period: "2020-04-05", Start: 1586059200, Messages: 3},
+	}
+	got, err := ActivityBuckets(times, IntervalDay, loc)
+	if err != nil {
+		t.Fatalf("ActivityBuckets returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ActivityBuckets = %+v, want %+v", got, want)
+	}
+}
+
+func TestActivityBucketsEmpty(t *testing.T) {
+	got, err := ActivityBuckets(nil, IntervalDay, time.UTC)
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("ActivityBuckets(nil) = %+v, %v, want an empty slice", got, err)
+	}
+	if _, err = ActivityBuckets(nil, "year", time.UTC); err == nil {
+		t.Errorf("ActivityBuckets with interval year returned no error")
+	}
+}
+
+func TestHourBuckets(t *testing.T) {
+	loc := santiago(t)
+	stats := HourBuckets([]TimeCount{
+		{Micros: 1567913400 * 1e6, Messages: 2}, // Saturday 23:30 -04
+		{Micros: 1567915200 * 1e6, Messages: 3}, // Sunday 01:00 -03
+		{Micros: 1567916100 * 1e6, Messages: 1}, // Sunday 01:15 -03
+	}, loc)
+	if stats.Hours[23] != 2 || stats.Hours[1] != 4 || stats.Hours[0] != 0 {
+		t.Errorf("Hours = %v, want 2 at 23:00 and 4 at 01:00", stats.Hours)
+	}
+	if stats.WeekdayHours[time.Saturday][23] != 2 || stats.WeekdayHours[time.Sunday][1] != 4 {
+		t.Errorf("WeekdayHours = %v, want 2 on Saturday at 23:00 and 4 on Sunday at 01:00", stats.WeekdayHours)
+	}
+	want := []HourCount{{Hour: 1, Messages: 4}, {Hour: 23, Messages: 2}}
+	if !reflect.DeepEqual(stats.Busiest, want) {
+		t.Errorf("Busiest = %+v, want %+v", stats.Busiest, want)
+	}
+}
+
+func TestCalendarDays(t *testing.T) {
+	loc := santiago(t)
+	days := CalendarDays([]TimeCount{
+		{Micros: 1567954800 * 1e6, Messages: 4}, // 2019-09-08 12:00 -03
+		{Micros: 1567913400 * 1e6, Messages: 2}, // 2019-09-07 23:30 -04
+		{Micros: 1567915200 * 1e6, Messages: 3}, // 2019-09-08 01:00 -03
+	}, loc, true)
+	if len(days) != 2 {
+		t.Fatalf("CalendarDays = %+v, want 2 days", days)
+	}
+	if days[0].Date != "2019-09-07" || days[0].Start != 1567828800 || days[0].Messages != 2 || days[0].Hours[23] != 2 {
+		t.Errorf("First day = %+v, want 2 messages on 2019-09-07 at 23:00", days[0])
+	}
+	if days[1].Date != "2019-09-08" || days[1].Start != 1567915200 || days[1].Messages != 7 ||
+		days[1].Hours[1] != 3 || days[1].Hours[12] != 4 {
+		t.Errorf("Second day = %+v, want 7 messages on 2019-09-08 at 01:00 and 12:00", days[1])
+	}
+	if days := CalendarDays([]TimeCount{{Micros: 1567954800 * 1e6, Messages: 1}}, loc, false); days[0].Hours != nil {
+		t.Errorf("CalendarDays without hours filled in Hours: %v", *days[0].Hours)
+	}
+}
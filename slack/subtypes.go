@@ -0,0 +1,110 @@
+package slack
+
+import (
+	"sort"
+)
+
+// Categories of system events that can be hidden from the message list
+const (
+	CategoryMembership   = "membership"
+	CategoryMetadata     = "metadata"
+	CategoryArchive      = "archive"
+	CategoryPins         = "pins"
+	CategoryHuddles      = "huddles"
+	CategoryIntegrations = "integrations"
+)
+
+var subtypeCategories = map[string]string{
+	"channel_join":               CategoryMembership,
+	"channel_leave":              CategoryMembership,
+	"group_join":                 CategoryMembership,
+	"group_leave":                CategoryMembership,
+	"channel_topic":              CategoryMetadata,
+	"channel_purpose":            CategoryMetadata,
+	"channel_name":               CategoryMetadata,
+	"group_topic":                CategoryMetadata,
+	"group_purpose":              CategoryMetadata,
+	"group_name":                 CategoryMetadata,
+	"channel_convert_to_private": CategoryMetadata,
+	"channel_convert_to_public":  CategoryMetadata,
+	"channel_archive":            CategoryArchive,
+	"channel_unarchive":          CategoryArchive,
+	"group_archive":              CategoryArchive,
+	"group_unarchive":            CategoryArchive,
+	"pinned_item":                CategoryPins,
+	"unpinned_item":              CategoryPins,
+	"huddle_thread":              CategoryHuddles,
+	"sh_room_created":            CategoryHuddles,
+	"bot_add":                    CategoryIntegrations,
+	"bot_remove":                 CategoryIntegrations,
+	"bot_enable":                 CategoryIntegrations,
+	"bot_disable":                CategoryIntegrations,
+	"app_conversation_join":      CategoryIntegrations,
+}
+
+// SystemCategories lists every system event category in sorted order
+func SystemCategories() []string {
+	seen := make(map[string]bool)
+	categories := make([]string, 0, 8)
+	for _, category := range subtypeCategories {
+		if !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// SubtypeCategory returns the system event category of a message subtype,
+// or the empty string if messages of that subtype are not system events
+func SubtypeCategory(subtype string) string {
+	return subtypeCategories[subtype]
+}
+
+// SubtypesInCategories returns the message subtypes
+// belonging to any of the given system event categories
+func SubtypesInCategories(categories []string) []string {
+	wanted := make(map[string]bool, len(categories))
+	for _, category := range categories {
+		wanted[category] = true
+	}
+	subtypes := make([]string, 0, 16)
+	for subtype, category := range subtypeCategories {
+		if wanted[category] {
+			subtypes = append(subtypes, subtype)
+		}
+	}
+	sort.Strings(subtypes)
+	return subtypes
+}
+
+// ChannelEventFromRaw extracts the channel metadata change
+// recorded by a system message, if there is one
+func ChannelEventFromRaw(message RawMessage, users Users) (ChannelEvent, bool) {
+	var field, value string
+	switch message.Subtype {
+	case "channel_topic", "group_topic":
+		field, value = "topic", message.Topic
+	case "channel_purpose", "group_purpose":
+		field, value = "purpose", message.Purpose
+	case "channel_name", "group_name":
+		field, value = "name", message.Name
+	case "channel_archive", "group_archive":
+		field, value = "archived", "true"
+	case "channel_unarchive", "group_unarchive":
+		field, value = "archived", "false"
+	default:
+		return ChannelEvent{}, false
+	}
+	timestamp, err := TimestampSeconds(message.Timestamp)
+	if err != nil {
+		return ChannelEvent{}, false
+	}
+	return ChannelEvent{
+		Timestamp: timestamp,
+		Field:     field,
+		Value:     value,
+		User:      realName(message.User, users),
+	}, true
+}
@@ -0,0 +1,184 @@
+package slack
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxSummarySentences is the longest a summary gets, however long the thread
+	maxSummarySentences = 5
+	// minSummaryTerms is how many terms a sentence needs to be worth quoting,
+	// unless no sentence in the thread has that many
+	minSummaryTerms = 3
+	// coveredTermDiscount is how much less a term is worth once a sentence
+	// using it has been picked
+	coveredTermDiscount = 0.3
+)
+
+var sentenceEnd = regexp.MustCompile(`[.!?]+\s+|\n+`)
+
+// SummaryMessage is a message in a thread being summarised
+// Reactions is the total number of reactions to it
+type SummaryMessage struct {
+	TS        string
+	User      string
+	Text      string
+	Reactions int
+}
+
+// Summary is returned from the API / to the front end
+// Sentences are in the order they were sent, and Text is them joined together
+type Summary struct {
+	Text      string            `json:"text"`
+	Sentences []SummarySentence `json:"sentences"`
+}
+
+// SummarySentence is a sentence quoted in a Summary
+type SummarySentence struct {
+	TS   string `json:"ts"`
+	User string `json:"user"`
+	Text string `json:"text"`
+}
+
+// summaryCandidate is a sentence that may be picked for a summary
+type summaryCandidate struct {
+	SummarySentence
+	terms  []string
+	boost  float64
+	picked bool
+}
+
+// Sentences splits message text into sentences, leaving out code blocks
+func Sentences(text string) []string {
+	text = codeFence.ReplaceAllString(text, "\n")
+	var sentences []string
+	add := func(sentence string) {
+		if sentence = strings.TrimSpace(sentence); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+	start := 0
+	for _, end := range sentenceEnd.FindAllStringIndex(text, -1) {
+		add(text[start:end[0]] + strings.TrimSpace(text[end[0]:end[1]]))
+		start = end[1]
+	}
+	add(text[start:])
+	return sentences
+}
+
+// uniqueTerms finds the distinct Terms of text, in the order they are first used
+func uniqueTerms(text string) []string {
+	terms := Terms(text)
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
+
+// countEligible counts the candidates with at least minTerms terms
+func countEligible(candidates []summaryCandidate, minTerms int) int {
+	eligible := 0
+	for _, c := range candidates {
+		if len(c.terms) >= minTerms {
+			eligible++
+		}
+	}
+	return eligible
+}
+
+// Summarize picks the sentences of a thread's messages that best sum it up.
+// Terms are weighted by how often they are used in the thread and by how
+// many of its authors use them, and sentences are scored by the weight of
+// their terms and the reactions to their message. Sentences are picked one
+// at a time, discounting the terms already covered and the authors already
+// quoted, so the summary repeats itself as little as possible
+func Summarize(messages []SummaryMessage) Summary {
+	var candidates []summaryCandidate
+	counts := make(map[string]int)
+	authors := make(map[string]map[string]bool)
+	total := 0
+	for _, msg := range messages {
+		boost := 1 + math.Log1p(float64(msg.Reactions))
+		for _, sentence := range Sentences(msg.Text) {
+			for _, term := range Terms(sentence) {
+				counts[term]++
+				if authors[term] == nil {
+					authors[term] = make(map[string]bool)
+				}
+				authors[term][msg.User] = true
+				total++
+			}
+			candidates = append(candidates, summaryCandidate{
+				SummarySentence: SummarySentence{TS: msg.TS, User: msg.User, Text: sentence},
+				terms:           uniqueTerms(sentence),
+				boost:           boost,
+			})
+		}
+	}
+	if total == 0 {
+		return Summary{Sentences: []SummarySentence{}}
+	}
+	weights := make(map[string]float64, len(counts))
+	for term, count := range counts {
+		weights[term] = float64(count) / float64(total) * float64(len(authors[term]))
+	}
+
+	minTerms := minSummaryTerms
+	eligible := countEligible(candidates, minTerms)
+	for eligible == 0 {
+		minTerms--
+		eligible = countEligible(candidates, minTerms)
+	}
+	length := int(math.Sqrt(float64(eligible)))
+	if length < 1 {
+		length = 1
+	} else if length > maxSummarySentences {
+		length = maxSummarySentences
+	}
+
+	quoted := make(map[string]int)
+	for picked := 0; picked < length; picked++ {
+		best, bestScore := -1, 0.0
+		for i, c := range candidates {
+			if c.picked || len(c.terms) < minTerms {
+				continue
+			}
+			var weight float64
+			for _, term := range c.terms {
+				weight += weights[term]
+			}
+			score := weight / math.Sqrt(float64(len(c.terms))) * c.boost / float64(1+quoted[c.User])
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		candidates[best].picked = true
+		quoted[candidates[best].User]++
+		for _, term := range candidates[best].terms {
+			weights[term] *= coveredTermDiscount
+		}
+	}
+
+	summary := Summary{Sentences: make([]SummarySentence, 0, length)}
+	for _, c := range candidates {
+		if c.picked {
+			summary.Sentences = append(summary.Sentences, c.SummarySentence)
+		}
+	}
+	texts := make([]string, len(summary.Sentences))
+	for i, sentence := range summary.Sentences {
+		texts[i] = sentence.Text
+	}
+	summary.Text = strings.Join(texts, " ")
+	return summary
+}
@@ -0,0 +1,116 @@
+package slack
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Deploy is done. Rollback next!  Really?", []string{"Deploy is done.", "Rollback next!", "Really?"}},
+		{"First line\nsecond line\n\n", []string{"First line", "second line"}},
+		{"Run this:\n```go test ./...```\nthen check the logs", []string{"Run this:", "then check the logs"}},
+		{"Version 1.2 works... mostly", []string{"Version 1.2 works...", "mostly"}},
+		{"", nil},
+		{"```only code```", nil},
+	}
+	for _, test := range tests {
+		got := Sentences(test.text)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Sentences(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []SummaryMessage
+		want     []string
+	}{
+		{
+			name: "empty",
+			want: []string{},
+		},
+		{
+			name:     "no terms",
+			messages: []SummaryMessage{{TS: "1.000001", User: "U1", Text: "ok :+1: <@U2>"}},
+			want:     []string{},
+		},
+		{
+			name:     "short sentences only",
+			messages: []SummaryMessage{{TS: "1.000001", User: "U1", Text: "Shipped it"}},
+			want:     []string{"Shipped it"},
+		},
+		{
+			name: "thread",
+			messages: []SummaryMessage{
+				{TS: "1.000001", User: "U1", Text: "The database migration failed on the replica."},
+				{TS: "1.000002", User: "U2", Text: "Anyone up for lunch later?"},
+				{TS: "1.000003", User: "U2", Text: "The migration needs a replica restart first."},
+				{TS: "1.000004", User: "U3", Text: "Restarted the replica, database migration passed.", Reactions: 3},
+			},
+			// The failure and the fix cover the restart between them
+			want: []string{
+				"The database migration failed on the replica.",
+				"Restarted the replica, database migration passed.",
+			},
+		},
+	}
+	for _, test := range tests {
+		got := Summarize(test.messages)
+		if got.Sentences == nil {
+			t.Errorf("%s: Summarize returned nil sentences", test.name)
+			continue
+		}
+		texts := make([]string, len(got.Sentences))
+		for i, sentence := range got.Sentences {
+			texts[i] = sentence.Text
+		}
+		if !reflect.DeepEqual(texts, test.want) {
+			t.Errorf("%s: Summarize = %q, want %q", test.name, texts, test.want)
+		}
+		if want := strings.Join(test.want, " "); got.Text != want {
+			t.Errorf("%s: Summarize text = %q, want %q", test.name, got.Text, want)
+		}
+	}
+}
+
+func TestSummarizeLength(t *testing.T) {
+	tests := []struct {
+		sentences int
+		want      int
+	}{
+		{1, 1},
+		{3, 1},
+		{4, 2},
+		{16, 4},
+		{100, maxSummarySentences},
+	}
+	for _, test := range tests {
+		messages := make([]SummaryMessage, test.sentences)
+		for i := range messages {
+			messages[i] = SummaryMessage{
+				TS:   MicrosTimestamp(int64(i + 1)),
+				User: fmt.Sprintf("U%d", i%3),
+				Text: fmt.Sprintf("Checked server%d cluster%d logs", i, i%4),
+			}
+		}
+		got := Summarize(messages)
+		if len(got.Sentences) != test.want {
+			t.Errorf("Summarize of %d sentences picked %d, want %d", test.sentences, len(got.Sentences), test.want)
+			continue
+		}
+		for i := 1; i < len(got.Sentences); i++ {
+			if got.Sentences[i-1].TS >= got.Sentences[i].TS {
+				t.Errorf("Summarize of %d sentences is out of order: %+v", test.sentences, got.Sentences)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package slack
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	labelledLink = regexp.MustCompile(`<[^>|]+\|([^>]*)>`)
+	slackMarkup  = regexp.MustCompile(`<[^>]*>|:[a-z0-9_+'-]+:|(^|\s)@\S+`)
+)
+
+// stopWords are common English words that say nothing about a message's topic
+var stopWords = toSet(strings.Fields(`
+	about above after again against all also and any are aren't because been before being below
+	between both but can can't cannot could couldn't did didn't does doesn't doing don't down during
+	each few for from further get got had hadn't has hasn't have haven't having her here hers herself
+	him himself his how i'm i've into isn't it's its itself just let's like more most mustn't myself
+	nor not now off once only other ought our ours ourselves out over own same she should shouldn't
+	some such than that that's the their theirs them themselves then there there's these they they're
+	this those through too under until very was wasn't way we're we've were weren't what what's when
+	where which while who whom why will with won't would wouldn't yes yet you you'd you'll you're
+	you've your yours yourself yourselves
+`))
+
+func toSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
+
+// Words splits message text into lower case words, dropping links,
+// emoji and @mentions. The labels of links are kept
+func Words(text string) []string {
+	text = labelledLink.ReplaceAllString(text, " $1 ")
+	text = slackMarkup.ReplaceAllString(text, " ")
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	for i, word := range words {
+		words[i] = strings.Trim(word, "'")
+	}
+	return words
+}
+
+// isTerm checks whether a word from Words says something about a topic:
+// it must have three or more letters or digits, at least one letter,
+// and not be a stop word
+func isTerm(word string, stop map[string]bool) bool {
+	return len([]rune(word)) >= 3 && !stop[word] && strings.IndexFunc(word, unicode.IsLetter) >= 0
+}
+
+// Terms splits message text into lower case words for comparing
+// what messages are about, dropping links, emoji, @mentions, numbers,
+// words shorter than three letters and stop words.
+// The labels of links are kept
+func Terms(text string) []string {
+	words := Words(text)
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if isTerm(word, stopWords) {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
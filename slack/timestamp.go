@@ -0,0 +1,40 @@
+package slack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TimestampMicros converts a Slack ts string such as "1588412758.000100"
+// into the exact number of microseconds since the UNIX epoch
+func TimestampMicros(ts string) (int64, error) {
+	parts := strings.SplitN(ts, ".", 2)
+	secs, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid timestamp %q: %v", ts, err)
+	}
+	var micros int64
+	if len(parts) == 2 && parts[1] != "" {
+		fraction := (parts[1] + "000000")[:6]
+		if micros, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return 0, fmt.Errorf("Invalid timestamp %q: %v", ts, err)
+		}
+	}
+	return secs*1e6 + micros, nil
+}
+
+// TimestampSeconds converts a Slack ts string into whole seconds since the UNIX epoch
+func TimestampSeconds(ts string) (uint64, error) {
+	micros, err := TimestampMicros(ts)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(micros / 1e6), nil
+}
+
+// MicrosTimestamp converts microseconds since the UNIX epoch
+// back into a Slack ts string
+func MicrosTimestamp(micros int64) string {
+	return fmt.Sprintf("%d.%06d", micros/1e6, micros%1e6)
+}
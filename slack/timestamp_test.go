@@ -0,0 +1,55 @@
+package slack
+
+import "testing"
+
+func TestTimestampMicros(t *testing.T) {
+	tests := []struct {
+		ts   string
+		want int64
+	}{
+		{"1588412758.000100", 1588412758000100},
+		{"1588412758.1", 1588412758100000},
+		{"1588412758.", 1588412758000000},
+		{"1588412758", 1588412758000000},
+		{"1588412758.1234567", 1588412758123456},
+		{"0.000001", 1},
+	}
+	for _, test := range tests {
+		got, err := TimestampMicros(test.ts)
+		if err != nil {
+			t.Errorf("TimestampMicros(%q) returned error: %v", test.ts, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("TimestampMicros(%q) = %d, want %d", test.ts, got, test.want)
+		}
+	}
+}
+
+func TestTimestampMicrosInvalid(t *testing.T) {
+	for _, ts := range []string{"", "abc", ".000100", "1588412758.x", "1588412758.00a100"} {
+		if got, err := TimestampMicros(ts); err == nil {
+			t.Errorf("TimestampMicros(%q) = %d, want error", ts, got)
+		}
+	}
+}
+
+func TestMicrosTimestamp(t *testing.T) {
+	tests := []struct {
+		micros int64
+		want   string
+	}{
+		{1588412758000100, "1588412758.000100"},
+		{1588412758100000, "1588412758.100000"},
+		{1, "0.000001"},
+	}
+	for _, test := range tests {
+		got := MicrosTimestamp(test.micros)
+		if got != test.want {
+			t.Errorf("MicrosTimestamp(%d) = %q, want %q", test.micros, got, test.want)
+		}
+		if back, err := TimestampMicros(got); err != nil || back != test.micros {
+			t.Errorf("TimestampMicros(%q) = %d, %v, want %d", got, back, err, test.micros)
+		}
+	}
+}
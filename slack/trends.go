@@ -0,0 +1,243 @@
+package slack
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// minDistinctiveCount is how many times a term must be used
+// to be reported as distinctive, to leave out one-off words
+const minDistinctiveCount = 2
+
+// TimedText is read from the db
+type TimedText struct {
+	Channel string
+	Micros  int64
+	Text    string
+}
+
+// TermSeries is returned from the API / to the front end
+// Buckets count the term across every channel, and Channels
+// break them down for each channel the term was used in
+type TermSeries struct {
+	Term     string          `json:"term"`
+	Total    int             `json:"total"`
+	Buckets  []TermBucket    `json:"buckets"`
+	Channels []ChannelSeries `json:"channels"`
+}
+
+// ChannelSeries is returned from the API / to the front end
+type ChannelSeries struct {
+	Channel string       `json:"channel"`
+	Total   int          `json:"total"`
+	Buckets []TermBucket `json:"buckets"`
+}
+
+// TermBucket is returned from the API / to the front end
+// Period is the date the bucket starts on, or the month for monthly buckets
+type TermBucket struct {
+	Period string `json:"period"`
+	Start  uint64 `json:"start"`
+	Count  int    `json:"count"`
+}
+
+// DistinctiveTerm is returned from the API / to the front end
+// BackgroundCount is how many times the term was used in the rest of the
+// archive, and Score is the log-likelihood of the difference in usage
+type DistinctiveTerm struct {
+	Term            string  `json:"term"`
+	Count           int     `json:"count"`
+	BackgroundCount int     `json:"background_count"`
+	Score           float64 `json:"score"`
+}
+
+// StopWords makes the set of words left out of distinctive terms,
+// the default English stop words if defaults is set, and extra
+func StopWords(extra []string, defaults bool) map[string]bool {
+	stop := make(map[string]bool, len(stopWords)+len(extra))
+	if defaults {
+		for word := range stopWords {
+			stop[word] = true
+		}
+	}
+	for _, word := range extra {
+		stop[strings.ToLower(word)] = true
+	}
+	return stop
+}
+
+// countPhrase counts the times the words of phrase appear in a row in words
+func countPhrase(words, phrase []string) int {
+	count := 0
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j, word := range phrase {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			count++
+		}
+	}
+	return count
+}
+
+// TermTrends counts the times each term, which may be several words long,
+// was used in messages per day, week or month in loc. Every period from
+// the first message to the last is included, so terms can be compared
+func TermTrends(messages []TimedText, terms []string, interval string, loc *time.Location) ([]TermSeries, error) {
+	if interval != IntervalDay && interval != IntervalWeek && interval != IntervalMonth {
+		return nil, fmt.Errorf("Invalid interval: %s", interval)
+	}
+	phrases := make([][]string, len(terms))
+	for i, term := range terms {
+		if phrases[i] = Words(term); len(phrases[i]) == 0 {
+			return nil, fmt.Errorf("Invalid term: %s", term)
+		}
+	}
+
+	var periods []time.Time
+	index := make(map[int64]int)
+	if len(messages) > 0 {
+		first, last := messages[0].Micros, messages[0].Micros
+		for _, msg := range messages {
+			if msg.Micros < first {
+				first = msg.Micros
+			}
+			if msg.Micros > last {
+				last = msg.Micros
+			}
+		}
+		end := periodStart(time.Unix(0, last*1e3).In(loc), interval)
+		for start := periodStart(time.Unix(0, first*1e3).In(loc), interval); !start.After(end); start = nextPeriod(start, interval) {
+			index[start.Unix()] = len(periods)
+			periods = append(periods, start)
+		}
+	}
+	layout := "2006-01-02"
+	if interval == IntervalMonth {
+		layout = "2006-01"
+	}
+	newBuckets := func() []TermBucket {
+		buckets := make([]TermBucket, len(periods))
+		for i, start := range periods {
+			buckets[i] = TermBucket{Period: start.Format(layout), Start: uint64(start.Unix())}
+		}
+		return buckets
+	}
+
+	series := make([]TermSeries, len(terms))
+	channels := make([]map[string]int, len(terms))
+	for i, term := range terms {
+		series[i] = TermSeries{Term: term, Buckets: newBuckets(), Channels: make([]ChannelSeries, 0)}
+		channels[i] = make(map[string]int)
+	}
+	for _, msg := range messages {
+		words := Words(msg.Text)
+		start := periodStart(time.Unix(0, msg.Micros*1e3).In(loc), interval)
+		period, ok := index[start.Unix()]
+		if !ok {
+			return nil, fmt.Errorf("No period starting at %s for message in %s", start.Format(time.RFC3339), msg.Channel)
+		}
+		for i, phrase := range phrases {
+			count := countPhrase(words, phrase)
+			if count == 0 {
+				continue
+			}
+			s := &series[i]
+			s.Total += count
+			s.Buckets[period].Count += count
+			c, ok := channels[i][msg.Channel]
+			if !ok {
+				c = len(s.Channels)
+				channels[i][msg.Channel] = c
+				s.Channels = append(s.Channels, ChannelSeries{Channel: msg.Channel, Buckets: newBuckets()})
+			}
+			s.Channels[c].Total += count
+			s.Channels[c].Buckets[period].Count += count
+		}
+	}
+	for i := range series {
+		sort.SliceStable(series[i].Channels, func(a, b int) bool {
+			return series[i].Channels[a].Total > series[i].Channels[b].Total
+		})
+	}
+	return series, nil
+}
+
+// CountTerms counts the n-grams of up to n words in texts, leaving out those
+// starting or ending with a stop word or a word that is not a term
+func CountTerms(texts []string, n int, stop map[string]bool) map[string]int {
+	counts := make(map[string]int)
+	for _, text := range texts {
+		words := Words(text)
+		for i, word := range words {
+			if !isTerm(word, stop) {
+				continue
+			}
+			for j := i; j < len(words) && j < i+n; j++ {
+				if isTerm(words[j], stop) {
+					counts[strings.Join(words[i:j+1], " ")]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// DistinctiveTerms ranks the terms used more in counts than in the rest of
+// the archive by their log-likelihood. archive counts the terms in every
+// message, including those counted in counts
+func DistinctiveTerms(counts, archive map[string]int, limit int) []DistinctiveTerm {
+	var total, backgroundTotal float64
+	background := make(map[string]int, len(archive))
+	for term, count := range archive {
+		background[term] = count - counts[term]
+		backgroundTotal += float64(background[term])
+	}
+	for _, count := range counts {
+		total += float64(count)
+	}
+	terms := make([]DistinctiveTerm, 0, len(counts))
+	// With nothing left in the rest of the archive, no term
+	// can be used more than it is there
+	if total == 0 || backgroundTotal <= 0 {
+		return terms
+	}
+	for term, count := range counts {
+		if count < minDistinctiveCount {
+			continue
+		}
+		a, b := float64(count), float64(background[term])
+		if a/total <= b/backgroundTotal {
+			continue
+		}
+		expected := total * (a + b) / (total + backgroundTotal)
+		score := a * math.Log(a/expected)
+		if b > 0 {
+			expectedBackground := backgroundTotal * (a + b) / (total + backgroundTotal)
+			score += b * math.Log(b/expectedBackground)
+		}
+		terms = append(terms, DistinctiveTerm{
+			Term:            term,
+			Count:           count,
+			BackgroundCount: background[term],
+			Score:           2 * score,
+		})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Score != terms[j].Score {
+			return terms[i].Score > terms[j].Score
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > limit {
+		terms = terms[:limit]
+	}
+	return terms
+}
@@ -0,0 +1,126 @@
+package slack
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTermTrends(t *testing.T) {
+	messages := []TimedText{
+		{Channel: "C1", Micros: 1578182400 * 1e6, Text: "Deploy the release train. deploy again"}, // 2020-01-05
+		{Channel: "C2", Micros: 1583798400 * 1e6, Text: "The release train left"},                 // 2020-03-10
+		{Channel: "C1", Micros: 1583884800 * 1e6, Text: "*release*, train"},                       // 2020-03-11
+	}
+	buckets := func(jan, feb, mar int) []TermBucket {
+		return []TermBucket{
+			{Period: "2020-01", Start: 1577836800, Count: jan},
+			{Period: "2020-02", Start: 1580515200, Count: feb},
+			{Period: "2020-03", Start: 1583020800, Count: mar},
+		}
+	}
+	want := []TermSeries{
+		{
+			Term:    "release train",
+			Total:   3,
+			Buckets: buckets(1, 0, 2),
+			Channels: []ChannelSeries{
+				{Channel: "C1", Total: 2, Buckets: buckets(1, 0, 1)},
+				{Channel: "C2", Total: 1, Buckets: buckets(0, 0, 1)},
+			},
+		},
+		{
+			Term:     "Deploy",
+			Total:    2,
+			Buckets:  buckets(2, 0, 0),
+			Channels: []ChannelSeries{{Channel: "C1", Total: 2, Buckets: buckets(2, 0, 0)}},
+		},
+		{
+			Term:     "rollback",
+			Buckets:  buckets(0, 0, 0),
+			Channels: []ChannelSeries{},
+		},
+	}
+	got, err := TermTrends(messages, []string{"release train", "Deploy", "rollback"}, IntervalMonth, time.UTC)
+	if err != nil {
+		t.Fatalf("TermTrends returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TermTrends = %+v, want %+v", got, want)
+	}
+}
+
+func TestTermTrendsInvalid(t *testing.T) {
+	tests := []struct {
+		terms    []string
+		interval string
+	}{
+		{[]string{"deploy"}, "year"},
+		{[]string{"deploy", "!!!"}, IntervalDay},
+		{[]string{""}, IntervalDay},
+	}
+	for _, test := range tests {
+		if got, err := TermTrends(nil, test.terms, test.interval, time.UTC); err == nil {
+			t.Errorf("TermTrends(%q, %s) = %+v, want error", test.terms, test.interval, got)
+		}
+	}
+}
+
+func TestDistinctiveTerms(t *testing.T) {
+	archive := map[string]int{"kubernetes": 6, "deploys": 3, "lunch": 40, "once": 1, "meeting": 53}
+	tests := []struct {
+		name   string
+		counts map[string]int
+		limit  int
+		want   []string
+	}{
+		{
+			name:   "ranked by score",
+			counts: map[string]int{"kubernetes": 5, "deploys": 3, "lunch": 2, "once": 1},
+			limit:  10,
+			// lunch is used less than in the rest of the archive,
+			// and once too few times
+			want: []string{"kubernetes", "deploys"},
+		},
+		{
+			name:   "limited",
+			counts: map[string]int{"kubernetes": 5, "deploys": 3, "lunch": 2, "once": 1},
+			limit:  1,
+			want:   []string{"kubernetes"},
+		},
+		{
+			name:   "whole archive",
+			counts: archive,
+			limit:  10,
+			want:   []string{},
+		},
+		{
+			name:   "nothing selected",
+			counts: map[string]int{},
+			limit:  10,
+			want:   []string{},
+		},
+	}
+	for _, test := range tests {
+		got := DistinctiveTerms(test.counts, archive, test.limit)
+		if got == nil {
+			t.Errorf("%s: DistinctiveTerms returned nil", test.name)
+			continue
+		}
+		terms := make([]string, len(got))
+		for i, term := range got {
+			terms[i] = term.Term
+			if math.IsNaN(term.Score) || math.IsInf(term.Score, 0) || term.Score <= 0 {
+				t.Errorf("%s: %s has score %v", test.name, term.Term, term.Score)
+			}
+			if term.Count != test.counts[term.Term] || term.BackgroundCount != archive[term.Term]-test.counts[term.Term] {
+				t.Errorf("%s: %s counted %d and %d in the background, want %d and %d", test.name, term.Term,
+					term.Count, term.BackgroundCount, test.counts[term.Term], archive[term.Term]-test.counts[term.Term])
+			}
+		}
+		if !reflect.DeepEqual(terms, test.want) {
+			t.Errorf("%s: DistinctiveTerms = %q, want %q", test.name, terms, test.want)
+		}
+	}
+}
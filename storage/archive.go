@@ -5,46 +5,183 @@ import (
 	"encoding/json"
 	"fmt"
 	"slack-backer-upper/slack"
+	"sync"
 )
 
 // ArchiveDBHandle is a handle to the database plus resources
 // needed to handle inserting information into it
 type ArchiveDBHandle struct {
-	db         *sql.DB
-	addMessage *sql.Stmt
-	addUser    *sql.Stmt
+	db              *sql.DB
+	addMessage      *sql.Stmt
+	addUser         *sql.Stmt
+	addChannel      *sql.Stmt
+	addChannelEvent *sql.Stmt
+	addPin          *sql.Stmt
+	updatePin       *sql.Stmt
+	addBookmark     *sql.Stmt
+	addThread       *sql.Stmt
+	addReaction     *sql.Stmt
+	addReactionUser *sql.Stmt
+	addCodeBlock    *sql.Stmt
+	addMention      *sql.Stmt
+	addLink         *sql.Stmt
+	addAttachment   *sql.Stmt
+	clearSummary    *sql.Stmt
+	addReference    *sql.Stmt
+	addOrphan       *sql.Stmt
+	clearOrphan     *sql.Stmt
+	// addSearchText is nil if SQLite was built without full-text search
+	addSearchText *sql.Stmt
+	// autolinks are the rules new messages' references are indexed with,
+	// which can change while an import is running
+	autolinks    []slack.CompiledAutolinkRule
+	autolinkLock sync.RWMutex
 }
 
 // Close closes resources specific to the ArchiveDBHandle
 // but not the underlying DB itself
 func (d *ArchiveDBHandle) Close() error {
-	if err := d.addMessage.Close(); err != nil {
-		return err
-	}
-	return d.addUser.Close()
+	return closeAll(
+		d.addMessage, d.addUser, d.addChannel, d.addChannelEvent, d.addPin, d.updatePin, d.addBookmark,
+		d.addThread, d.addReaction, d.addReactionUser, d.addCodeBlock, d.addMention, d.addLink,
+		d.addAttachment, d.clearSummary, d.addReference, d.addOrphan, d.clearOrphan, d.addSearchText,
+	)
 }
 
 // Archiver creates and returns a handle to the initialized database,
 // creates the necessary tables, and prepares the necessary statements
 func Archiver(db *sql.DB) (*ArchiveDBHandle, error) {
-	addMessage, err := db.Prepare("INSERT OR IGNORE INTO messages VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
-	if err != nil {
+	d := &ArchiveDBHandle{db: db}
+	if err := prepareAll(db, map[**sql.Stmt]string{
+		&d.addMessage: `
+			INSERT OR IGNORE INTO messages
+				(channel, timestamp, txt, user, attachments, reacts, parent, top_level, subtype, ts_micros,
+					has_link, has_file, user_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		&d.addUser: "INSERT OR IGNORE INTO users VALUES (?, ?, ?)",
+		&d.addChannel: `
+			INSERT OR REPLACE INTO channels (id, name, created, creator, archived, topic, purpose)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+		`,
+		&d.addChannelEvent: `
+			INSERT OR IGNORE INTO channel_history (channel, timestamp, field, value, user)
+				VALUES (?, ?, ?, ?, ?)
+		`,
+		&d.addPin: `
+			INSERT INTO pins (channel, timestamp, pinned_by, pinned_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (channel, timestamp) DO UPDATE SET
+					pinned_by = CASE WHEN excluded.pinned_by = "" THEN pinned_by ELSE excluded.pinned_by END,
+					pinned_at = CASE WHEN excluded.pinned_at = 0 THEN pinned_at ELSE excluded.pinned_at END
+		`,
+		&d.updatePin: `
+			UPDATE pins SET pinned_by = ?, pinned_at = ? WHERE channel = ? AND timestamp = ? AND pinned_at <= ?
+		`,
+		&d.addBookmark: `
+			INSERT OR REPLACE INTO bookmarks (channel, id, title, link, emoji, created, creator)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+		`,
+		&d.addThread: `
+			INSERT INTO threads (channel, timestamp, reply_count, reply_users, latest_reply, latest_micros)
+				VALUES (?, ?, ?, ?, ?, ?)
+				ON CONFLICT (channel, timestamp) DO UPDATE SET
+					reply_count = excluded.reply_count, reply_users = excluded.reply_users,
+					latest_reply = excluded.latest_reply, latest_micros = excluded.latest_micros
+				WHERE excluded.latest_micros >= latest_micros
+		`,
+		&d.addReaction: `
+			INSERT OR REPLACE INTO reactions (channel, timestamp, name, emoji, count) VALUES (?, ?, ?, ?, ?)
+		`,
+		&d.addReactionUser: `
+			INSERT OR IGNORE INTO reaction_users (channel, timestamp, name, emoji, user_id) VALUES (?, ?, ?, ?, ?)
+		`,
+		&d.addCodeBlock:  insertCodeBlock,
+		&d.addMention:    insertMention,
+		&d.addLink:       insertLink,
+		&d.addAttachment: insertAttachment,
+		&d.clearSummary:  "DELETE FROM thread_summaries WHERE channel = ? AND timestamp IN (?, ?)",
+		&d.addReference:  insertReference,
+		&d.addOrphan: `
+			INSERT OR IGNORE INTO orphan_threads (channel, timestamp, ts_micros)
+				SELECT ?1, ?2, ?3 WHERE NOT EXISTS (SELECT 1 FROM messages WHERE channel = ?1 AND timestamp = ?2)
+		`,
+		&d.clearOrphan: "DELETE FROM orphan_threads WHERE channel = ? AND timestamp = ?",
+	}); err != nil {
 		return nil, err
 	}
-	addUser, err := db.Prepare("INSERT OR IGNORE INTO users VALUES (?, ?, ?)")
-	if err != nil {
-		addMessage.Close()
+	var err error
+	if d.autolinks, err = compileAutolinkRules(db); err != nil {
+		d.Close()
 		return nil, err
 	}
-	return &ArchiveDBHandle{
-		db:         db,
-		addMessage: addMessage,
-		addUser:    addUser,
-	}, nil
+	if searchAvailable(db) {
+		if d.addSearchText, err = db.Prepare(
+			"INSERT INTO messages_fts (txt, channel, timestamp) VALUES (?, ?, ?)",
+		); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// messageWriter adds messages to the DB in a transaction,
+// with the statements for each table the message is indexed in
+type messageWriter struct {
+	addMessage      *sql.Stmt
+	addCodeBlock    *sql.Stmt
+	addMention      *sql.Stmt
+	addLink         *sql.Stmt
+	addAttachment   *sql.Stmt
+	addReference    *sql.Stmt
+	clearOrphan     *sql.Stmt
+	addOrphan       *sql.Stmt
+	clearSummary    *sql.Stmt
+	addReaction     *sql.Stmt
+	addReactionUser *sql.Stmt
+	addThread       *sql.Stmt
+	// addSearchText is nil if SQLite was built without full-text search
+	addSearchText *sql.Stmt
+	autolinks     []slack.CompiledAutolinkRule
+}
+
+// AddMessages adds msgs into the DB associated with channelName. They are
+// added in one transaction, so the tables indexing messages never get out
+// of step with the messages themselves if adding one fails
+func (d *ArchiveDBHandle) AddMessages(channelName string, msgs []slack.StoredMessage) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	w := messageWriter{
+		addMessage:      tx.Stmt(d.addMessage),
+		addCodeBlock:    tx.Stmt(d.addCodeBlock),
+		addMention:      tx.Stmt(d.addMention),
+		addLink:         tx.Stmt(d.addLink),
+		addAttachment:   tx.Stmt(d.addAttachment),
+		addReference:    tx.Stmt(d.addReference),
+		clearOrphan:     tx.Stmt(d.clearOrphan),
+		addOrphan:       tx.Stmt(d.addOrphan),
+		clearSummary:    tx.Stmt(d.clearSummary),
+		addReaction:     tx.Stmt(d.addReaction),
+		addReactionUser: tx.Stmt(d.addReactionUser),
+		addThread:       tx.Stmt(d.addThread),
+		autolinks:       d.autolinkRules(),
+	}
+	if d.addSearchText != nil {
+		w.addSearchText = tx.Stmt(d.addSearchText)
+	}
+	for _, msg := range msgs {
+		if err = w.add(channelName, msg); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// AddMessage adds msg into the DB associated with channelName
-func (d *ArchiveDBHandle) AddMessage(channelName string, msg slack.StoredMessage) error {
+// add adds msg into the DB associated with channelName
+func (w *messageWriter) add(channelName string, msg slack.StoredMessage) error {
 	attach, err := json.Marshal(msg.Attachments)
 	if err != nil {
 		return err
@@ -53,11 +190,95 @@ func (d *ArchiveDBHandle) AddMessage(channelName string, msg slack.StoredMessage
 	if err != nil {
 		return err
 	}
-	if _, err = d.addMessage.Exec(
+	micros, err := slack.TimestampMicros(msg.Timestamp)
+	if err != nil {
+		return err
+	}
+	result, err := w.addMessage.Exec(
 		channelName, msg.Timestamp, msg.Text, msg.User, attach, reacc, msg.ParentTimestamp, msg.DisplayTopLevel,
-	); err != nil {
+		msg.Subtype, micros, msg.HasLink, msg.HasFile, msg.UserID,
+	)
+	if err != nil {
 		return err
 	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted > 0 && w.addSearchText != nil {
+		if _, err = w.addSearchText.Exec(msg.Text, channelName, msg.Timestamp); err != nil {
+			return err
+		}
+	}
+	if inserted > 0 {
+		if err = addCodeBlocks(w.addCodeBlock, channelName, msg.Timestamp, slack.ExtractCodeBlocks(msg.Text)); err != nil {
+			return err
+		}
+		if err = addMentions(w.addMention, channelName, msg.Timestamp, msg.Mentions); err != nil {
+			return err
+		}
+		if err = addLinks(
+			w.addLink, channelName, msg.Timestamp, msg.User, micros, slack.ExtractLinks(msg.Text, msg.Attachments),
+		); err != nil {
+			return err
+		}
+		if err = addAttachments(w.addAttachment, channelName, msg.Timestamp, micros, msg.Attachments); err != nil {
+			return err
+		}
+		if err = addReferences(
+			w.addReference, channelName, msg.Timestamp, micros, slack.FindAutolinks(msg.Text, w.autolinks),
+		); err != nil {
+			return err
+		}
+		// Threads whose parent is missing are listed with a placeholder
+		// for it until the parent is imported
+		if _, err = w.clearOrphan.Exec(channelName, msg.Timestamp); err != nil {
+			return err
+		}
+		if msg.ParentTimestamp != "" {
+			parentMicros, err := slack.TimestampMicros(msg.ParentTimestamp)
+			if err != nil {
+				return err
+			}
+			if _, err = w.addOrphan.Exec(channelName, msg.ParentTimestamp, parentMicros); err != nil {
+				return err
+			}
+		}
+	}
+	if inserted > 0 || len(msg.Reactions) > 0 {
+		// The thread's cached summary is made again the next time it is asked for
+		if _, err = w.clearSummary.Exec(channelName, msg.Timestamp, msg.ParentTimestamp); err != nil {
+			return err
+		}
+	}
+	for _, react := range msg.Reactions {
+		emoji := slack.BaseEmoji(react.Name)
+		if _, err = w.addReaction.Exec(channelName, msg.Timestamp, react.Name, emoji, react.Count); err != nil {
+			return err
+		}
+		for _, user := range react.Users {
+			if _, err = w.addReactionUser.Exec(channelName, msg.Timestamp, react.Name, emoji, user); err != nil {
+				return err
+			}
+		}
+	}
+	if msg.ReplyCount > 0 {
+		replyUsers, err := json.Marshal(msg.ReplyUsers)
+		if err != nil {
+			return err
+		}
+		var latestMicros int64
+		if msg.LatestReply != "" {
+			if latestMicros, err = slack.TimestampMicros(msg.LatestReply); err != nil {
+				return err
+			}
+		}
+		if _, err = w.addThread.Exec(
+			channelName, msg.Timestamp, msg.ReplyCount, replyUsers, msg.LatestReply, latestMicros,
+		); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -70,3 +291,46 @@ func (d *ArchiveDBHandle) AddUsers(users slack.Users) error {
 	}
 	return nil
 }
+
+// AddChannels inserts or updates channel metadata,
+// including pins and bookmarks, in the DB
+func (d *ArchiveDBHandle) AddChannels(channels []slack.StoredChannel) error {
+	for _, c := range channels {
+		if _, err := d.addChannel.Exec(
+			c.ID, c.Name, c.Created, c.Creator, c.Archived, c.Topic, c.Purpose,
+		); err != nil {
+			return fmt.Errorf("Error inserting channel: %v", err)
+		}
+		for _, pin := range c.Pins {
+			if err := d.AddPin(c.Name, pin); err != nil {
+				return fmt.Errorf("Error inserting pin: %v", err)
+			}
+		}
+		for _, b := range c.Bookmarks {
+			if _, err := d.addBookmark.Exec(c.Name, b.ID, b.Title, b.Link, b.Emoji, b.Created, b.Creator); err != nil {
+				return fmt.Errorf("Error inserting bookmark: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// AddChannelEvent records a change to a channel's metadata in the DB
+func (d *ArchiveDBHandle) AddChannelEvent(channelName string, event slack.ChannelEvent) error {
+	_, err := d.addChannelEvent.Exec(channelName, event.Timestamp, event.Field, event.Value, event.User)
+	return err
+}
+
+// AddPin records that a message in channelName is pinned,
+// keeping any known pinner and pin time if pin does not have them
+func (d *ArchiveDBHandle) AddPin(channelName string, pin slack.Pin) error {
+	_, err := d.addPin.Exec(channelName, pin.Timestamp, pin.PinnedBy, pin.PinnedAt)
+	return err
+}
+
+// UpdatePin fills in who pinned an already pinned message and when.
+// Messages that are not pinned are left alone, since they were unpinned
+func (d *ArchiveDBHandle) UpdatePin(channelName string, pin slack.Pin) error {
+	_, err := d.updatePin.Exec(pin.PinnedBy, pin.PinnedAt, channelName, pin.Timestamp, pin.PinnedAt)
+	return err
+}
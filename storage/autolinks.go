@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"slack-backer-upper/slack"
+	"time"
+)
+
+// ErrAutolinkRuleNotFound is returned when an autolink rule ID is not in the archive
+var ErrAutolinkRuleNotFound = errors.New("Autolink rule not found")
+
+const insertReference = `
+	INSERT OR IGNORE INTO autolink_references (rule_id, channel, timestamp, reference, url, ts_micros)
+		VALUES (?, ?, ?, ?, ?, ?)
+`
+
+// loadAutolinkRules gets every autolink rule, oldest first
+func loadAutolinkRules(db *sql.DB) ([]slack.AutolinkRule, error) {
+	rows, err := db.Query("SELECT id, pattern, url, created FROM autolink_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := make([]slack.AutolinkRule, 0)
+	for rows.Next() {
+		var rule slack.AutolinkRule
+		if err = rows.Scan(&rule.ID, &rule.Pattern, &rule.URL, &rule.Created); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// compileAutolinkRules compiles the autolink rules in the archive
+func compileAutolinkRules(db *sql.DB) ([]slack.CompiledAutolinkRule, error) {
+	rules, err := loadAutolinkRules(db)
+	if err != nil {
+		return nil, err
+	}
+	compiled := make([]slack.CompiledAutolinkRule, len(rules))
+	for i, rule := range rules {
+		if compiled[i], err = slack.CompileAutolinkRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+func addReferences(stmt *sql.Stmt, channel, timestamp string, micros int64, autolinks []slack.Autolink) error {
+	for _, autolink := range autolinks {
+		if _, err := stmt.Exec(autolink.RuleID, channel, timestamp, autolink.Text, autolink.URL, micros); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// autolinkRules gets the rules messages are indexed with on import
+func (d *ArchiveDBHandle) autolinkRules() []slack.CompiledAutolinkRule {
+	d.autolinkLock.RLock()
+	defer d.autolinkLock.RUnlock()
+	return d.autolinks
+}
+
+// AddAutolinkRule saves a new autolink rule, and indexes the references
+// to it in every message already in the archive
+func (d *ArchiveDBHandle) AddAutolinkRule(rule slack.AutolinkRule) (slack.AutolinkRule, error) {
+	compiled, err := slack.CompileAutolinkRule(rule)
+	if err != nil {
+		return slack.AutolinkRule{}, err
+	}
+	compiled.Created = time.Now().Unix()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return slack.AutolinkRule{}, err
+	}
+	result, err := tx.Exec(
+		"INSERT INTO autolink_rules (pattern, url, created) VALUES (?, ?, ?)",
+		compiled.Pattern, compiled.URL, compiled.Created,
+	)
+	if err != nil {
+		tx.Rollback()
+		return slack.AutolinkRule{}, err
+	}
+	if compiled.ID, err = result.LastInsertId(); err != nil {
+		tx.Rollback()
+		return slack.AutolinkRule{}, err
+	}
+	if err = indexReferences(tx, compiled); err != nil {
+		tx.Rollback()
+		return slack.AutolinkRule{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return slack.AutolinkRule{}, err
+	}
+	d.autolinkLock.Lock()
+	d.autolinks = append(d.autolinks, compiled)
+	d.autolinkLock.Unlock()
+	return compiled.AutolinkRule, nil
+}
+
+// indexReferences indexes the references a new rule finds in the archive
+func indexReferences(tx *sql.Tx, rule slack.CompiledAutolinkRule) error {
+	rows, err := tx.Query(`SELECT channel, timestamp, txt, ts_micros FROM messages WHERE txt != ""`)
+	if err != nil {
+		return err
+	}
+	type referenced struct {
+		channel, timestamp string
+		micros             int64
+		autolinks          []slack.Autolink
+	}
+	found := make([]referenced, 0)
+	rules := []slack.CompiledAutolinkRule{rule}
+	for rows.Next() {
+		var r referenced
+		var text sql.NullString
+		if err = rows.Scan(&r.channel, &r.timestamp, &text, &r.micros); err != nil {
+			rows.Close()
+			return err
+		}
+		if r.autolinks = slack.FindAutolinks(text.String, rules); len(r.autolinks) > 0 {
+			found = append(found, r)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insertReference)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range found {
+		if err = addReferences(stmt, r.channel, r.timestamp, r.micros, r.autolinks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAutolinkRule deletes an autolink rule and the references it indexed
+func (d *ArchiveDBHandle) DeleteAutolinkRule(id int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM autolink_rules WHERE id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = ErrAutolinkRuleNotFound
+		}
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM autolink_references WHERE rule_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	d.autolinkLock.Lock()
+	rules := make([]slack.CompiledAutolinkRule, 0, len(d.autolinks))
+	for _, rule := range d.autolinks {
+		if rule.ID != id {
+			rules = append(rules, rule)
+		}
+	}
+	d.autolinks = rules
+	d.autolinkLock.Unlock()
+	return nil
+}
+
+// GetAutolinkRules gets every autolink rule, oldest first
+func (d *ViewerDBHandle) GetAutolinkRules() ([]slack.AutolinkRule, error) {
+	return loadAutolinkRules(d.db)
+}
+
+// SearchReferences finds the messages matching the modifiers of q that
+// an autolink rule found a reference in, such as a ticket ID, ignoring
+// case. Results are sorted most recent first, and replies come with the
+// parent message of their thread
+func (d *ViewerDBHandle) SearchReferences(
+	reference string, q slack.SearchQuery, limit, offset int,
+) ([]slack.StoredSearchResult, error) {
+	conditions, args := searchFilters(q)
+	query := `
+		SELECT ` + messageColumns("m") + ` FROM messages m
+			WHERE (m.channel, m.timestamp) IN (
+				SELECT r.channel, r.timestamp FROM autolink_references r WHERE r.reference = ? COLLATE NOCASE
+			)`
+	for _, condition := range conditions {
+		query += " AND " + condition
+	}
+	query += " ORDER BY m.ts_micros DESC, m.channel LIMIT ? OFFSET ?;"
+	rows, err := d.db.Query(query, append(append([]interface{}{reference}, args...), limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(reference))
+	messages := make([]slack.StoredMessage, 0, limit)
+	snippets := make([]string, 0, limit)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		snippet := msg.Text
+		if match := pattern.FindStringIndex(msg.Text); match != nil {
+			snippet = regexSnippet(msg.Text, match)
+		}
+		messages = append(messages, msg)
+		snippets = append(snippets, snippet)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return d.withThreadParents(messages, snippets)
+}
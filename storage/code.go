@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"database/sql"
+	"slack-backer-upper/slack"
+)
+
+const insertCodeBlock = `
+	INSERT OR IGNORE INTO code_blocks (channel, timestamp, position, language, code) VALUES (?, ?, ?, ?, ?)
+`
+
+// backfillCodeBlocks indexes the code blocks of messages
+// archived before code blocks were indexed on import
+func backfillCodeBlocks(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT channel, timestamp, txt FROM messages WHERE txt LIKE '%```%```%'")
+	if err != nil {
+		return err
+	}
+	type coded struct {
+		channel, timestamp string
+		blocks             []slack.CodeBlock
+	}
+	found := make([]coded, 0)
+	for rows.Next() {
+		var c coded
+		var text string
+		if err = rows.Scan(&c.channel, &c.timestamp, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		if c.blocks = slack.ExtractCodeBlocks(text); len(c.blocks) > 0 {
+			found = append(found, c)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insertCodeBlock)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range found {
+		if err = addCodeBlocks(stmt, c.channel, c.timestamp, c.blocks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addCodeBlocks(stmt *sql.Stmt, channel, timestamp string, blocks []slack.CodeBlock) error {
+	for i, block := range blocks {
+		if _, err := stmt.Exec(channel, timestamp, i, block.Language, block.Code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchCode finds the code blocks of messages matching the modifiers of q,
+// most recent first. Blocks must contain every term and phrase of q,
+// ignoring case, and none of its exclusions. lang: modifiers restrict
+// the blocks themselves, not just the messages they are in
+func (d *ViewerDBHandle) SearchCode(q slack.SearchQuery, limit, offset int) ([]slack.StoredCodeResult, error) {
+	// The blocks' own languages are matched below, which
+	// makes the filter on their messages' languages redundant
+	filters := q
+	filters.Languages = nil
+	conditions, args := searchFilters(filters)
+	for _, text := range append(append([]string{}, q.Terms...), q.Phrases...) {
+		conditions = append(conditions, "instr(lower(c.code), lower(?)) > 0")
+		args = append(args, text)
+	}
+	for _, text := range q.Excluded {
+		conditions = append(conditions, "instr(lower(c.code), lower(?)) = 0")
+		args = append(args, text)
+	}
+	if len(q.Languages) > 0 {
+		conditions = append(conditions, "c.language IN ("+placeholders(len(q.Languages))+")")
+		for _, language := range q.Languages {
+			args = append(args, language)
+		}
+	}
+	query := `
+		SELECT m.channel, m.timestamp, m.parent, m.user, c.language, c.code
+			FROM code_blocks c
+			JOIN messages m ON m.channel = c.channel AND m.timestamp = c.timestamp
+			WHERE true`
+	for _, condition := range conditions {
+		query += " AND " + condition
+	}
+	query += " ORDER BY m.ts_micros DESC, c.position LIMIT ? OFFSET ?;"
+	args = append(args, limit, offset)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]slack.StoredCodeResult, 0, limit)
+	for rows.Next() {
+		var result slack.StoredCodeResult
+		if err = rows.Scan(
+			&result.Channel, &result.Timestamp, &result.ParentTimestamp, &result.User,
+			&result.Block.Language, &result.Block.Code,
+		); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 
 	// go database drivers require _ import
 	_ "github.com/mattn/go-sqlite3"
@@ -12,13 +13,11 @@ type Storage interface {
 	Close() error
 }
 
-// New creates a new Storage backed by SQLite
-func New() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./slack.db?_journal=WAL")
-	if err != nil {
-		return nil, err
-	}
-	if _, err = db.Exec(`
+// migrations are applied in order to bring the schema up to date.
+// PRAGMA user_version records how many have already been applied,
+// so existing entries must never be edited, only appended to
+var migrations = []string{
+	`
 		CREATE TABLE IF NOT EXISTS messages (
 			channel TEXT NOT NULL, timestamp TEXT NOT NULL, txt TEXT, user TEXT,
 			attachments TEXT, reacts TEXT, parent TEXT, top_level BOOLEAN,
@@ -27,7 +26,263 @@ func New() (*sql.DB, error) {
 		CREATE TABLE IF NOT EXISTS users (
 			id TEXT UNIQUE, real_name TEXT, display_name TEXT
 		);
-	`); err != nil {
+	`,
+	`
+		ALTER TABLE messages ADD COLUMN subtype TEXT NOT NULL DEFAULT "";
+		CREATE TABLE channels (
+			id TEXT, name TEXT NOT NULL UNIQUE, created INTEGER, creator TEXT,
+			archived BOOLEAN, topic TEXT, purpose TEXT
+		);
+		CREATE TABLE channel_history (
+			channel TEXT NOT NULL, timestamp INTEGER NOT NULL, field TEXT NOT NULL,
+			value TEXT, user TEXT,
+			UNIQUE(channel, timestamp, field)
+		);
+	`,
+	`
+		CREATE TABLE pins (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, pinned_by TEXT NOT NULL DEFAULT "",
+			pinned_at INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(channel, timestamp)
+		);
+		CREATE TABLE bookmarks (
+			channel TEXT NOT NULL, id TEXT NOT NULL, title TEXT, link TEXT NOT NULL,
+			emoji TEXT, created INTEGER, creator TEXT,
+			UNIQUE(channel, id)
+		);
+	`,
+	`
+		CREATE TABLE threads (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, reply_count INTEGER NOT NULL,
+			reply_users TEXT, latest_reply TEXT NOT NULL,
+			UNIQUE(channel, timestamp)
+		);
+		CREATE INDEX messages_parent ON messages (channel, parent);
+	`,
+	`
+		ALTER TABLE messages ADD COLUMN ts_micros INTEGER NOT NULL DEFAULT 0;
+		UPDATE messages SET ts_micros = ` + sqlMicros("timestamp") + `;
+		CREATE INDEX messages_time ON messages (channel, ts_micros);
+		ALTER TABLE threads ADD COLUMN latest_micros INTEGER NOT NULL DEFAULT 0;
+		UPDATE threads SET latest_micros = ` + sqlMicros("latest_reply") + `;
+	`,
+	`
+		CREATE TABLE reactions (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, name TEXT NOT NULL, emoji TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			UNIQUE(channel, timestamp, name)
+		);
+		CREATE TABLE reaction_users (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, name TEXT NOT NULL, emoji TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			UNIQUE(channel, timestamp, name, user_id)
+		);
+		CREATE INDEX reaction_users_user ON reaction_users (user_id, emoji);
+	`,
+	`
+		ALTER TABLE messages ADD COLUMN has_link BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE messages ADD COLUMN has_file BOOLEAN NOT NULL DEFAULT false;
+		UPDATE messages SET
+			has_link = txt LIKE "%<http%" OR attachments LIKE '%"from_url":"http%',
+			has_file = attachments LIKE "%/files/%" OR attachments LIKE "%This file was deleted.%";
+	`,
+	`
+		CREATE TABLE code_blocks (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, position INTEGER NOT NULL,
+			language TEXT NOT NULL, code TEXT NOT NULL,
+			UNIQUE(channel, timestamp, position)
+		);
+		CREATE INDEX code_blocks_language ON code_blocks (language);
+	`,
+	`
+		CREATE TABLE related_documents (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, reply_count INTEGER NOT NULL,
+			latest_micros INTEGER NOT NULL, participants TEXT,
+			UNIQUE(channel, timestamp)
+		);
+		CREATE TABLE related_terms (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, term TEXT NOT NULL, weight REAL NOT NULL,
+			UNIQUE(channel, timestamp, term)
+		);
+		CREATE INDEX related_terms_term ON related_terms (term);
+	`,
+	`
+		DROP INDEX messages_parent;
+		CREATE INDEX messages_thread ON messages (channel, parent, ts_micros);
+	`,
+	`
+		CREATE TABLE mentions (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, user_id TEXT NOT NULL,
+			UNIQUE(channel, timestamp, user_id)
+		);
+		CREATE INDEX mentions_user ON mentions (user_id);
+	`,
+	`
+		CREATE TABLE links (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, position INTEGER NOT NULL,
+			url TEXT NOT NULL, label TEXT NOT NULL, domain TEXT NOT NULL, user TEXT NOT NULL,
+			ts_micros INTEGER NOT NULL,
+			UNIQUE(channel, timestamp, url)
+		);
+		CREATE INDEX links_url ON links (url);
+		CREATE INDEX links_domain ON links (domain, ts_micros);
+		CREATE INDEX links_time ON links (ts_micros);
+	`,
+	`
+		INSERT OR IGNORE INTO mentions (channel, timestamp, user_id)
+			SELECT channel, timestamp, "!channel" FROM messages
+				WHERE txt LIKE "%<!channel>%" OR txt LIKE "%<!channel|%";
+		INSERT OR IGNORE INTO mentions (channel, timestamp, user_id)
+			SELECT channel, timestamp, "!here" FROM messages
+				WHERE txt LIKE "%<!here>%" OR txt LIKE "%<!here|%";
+		INSERT OR IGNORE INTO mentions (channel, timestamp, user_id)
+			SELECT channel, timestamp, "!everyone" FROM messages
+				WHERE txt LIKE "%<!everyone>%" OR txt LIKE "%<!everyone|%";
+	`,
+	`
+		ALTER TABLE messages ADD COLUMN user_id TEXT NOT NULL DEFAULT "";
+		UPDATE messages SET user_id = COALESCE(
+			(SELECT CASE WHEN COUNT(*) = 1 THEN MIN(id) END FROM users WHERE real_name = messages.user),
+			CASE WHEN user GLOB "[UW][A-Z0-9]*" AND user NOT GLOB "*[^A-Z0-9]*" THEN user END,
+			""
+		);
+		CREATE INDEX messages_user ON messages (user_id, ts_micros);
+	`,
+	`
+		CREATE TABLE thread_summaries (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, summary TEXT NOT NULL,
+			UNIQUE(channel, timestamp)
+		);
+	`,
+	`
+		CREATE TABLE autolink_rules (
+			id INTEGER PRIMARY KEY, pattern TEXT NOT NULL, url TEXT NOT NULL, created INTEGER NOT NULL
+		);
+		CREATE TABLE autolink_references (
+			rule_id INTEGER NOT NULL, channel TEXT NOT NULL, timestamp TEXT NOT NULL,
+			reference TEXT NOT NULL, url TEXT NOT NULL, ts_micros INTEGER NOT NULL,
+			UNIQUE(rule_id, channel, timestamp, reference)
+		);
+		CREATE INDEX autolink_references_reference ON autolink_references (reference COLLATE NOCASE);
+	`,
+	`
+		CREATE TABLE orphan_threads (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, ts_micros INTEGER NOT NULL,
+			UNIQUE(channel, timestamp)
+		);
+		INSERT OR IGNORE INTO orphan_threads (channel, timestamp, ts_micros)
+			SELECT r.channel, r.parent, ` + sqlMicros("r.parent") + ` FROM messages r
+				WHERE r.parent != "" AND NOT EXISTS (
+					SELECT 1 FROM messages p WHERE p.channel = r.channel AND p.timestamp = r.parent
+				);
+		CREATE INDEX orphan_threads_time ON orphan_threads (ts_micros, channel);
+	`,
+	`
+		CREATE INDEX messages_micros ON messages (ts_micros, channel);
+	`,
+	`
+		CREATE TABLE attachments (
+			channel TEXT NOT NULL, timestamp TEXT NOT NULL, position INTEGER NOT NULL,
+			title TEXT NOT NULL, url TEXT NOT NULL, fallback TEXT NOT NULL, attach_ts TEXT NOT NULL,
+			extension TEXT NOT NULL, kind TEXT NOT NULL, ts_micros INTEGER NOT NULL,
+			UNIQUE(channel, timestamp, position)
+		);
+		CREATE INDEX attachments_time ON attachments (ts_micros, channel);
+	`,
+}
+
+// backfills fill in data for a migration that SQL alone can't derive,
+// keyed by the index of the migration they run after
+var backfills = map[int]func(*sql.Tx) error{
+	7:  backfillCodeBlocks,
+	8:  buildRelatedIndex,
+	10: backfillMentions,
+	11: backfillLinks,
+	18: backfillAttachments,
+}
+
+// sqlMicros is the SQL equivalent of slack.TimestampMicros
+// for converting a column of Slack ts strings during a migration
+func sqlMicros(column string) string {
+	return fmt.Sprintf(`CASE WHEN instr(%[1]s, ".") = 0 THEN CAST(%[1]s AS INTEGER) * 1000000
+		ELSE CAST(substr(%[1]s, 1, instr(%[1]s, ".") - 1) AS INTEGER) * 1000000
+			+ CAST(substr(%[1]s || "000000", instr(%[1]s, ".") + 1, 6) AS INTEGER) END`, column)
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error applying migration %d: %v", version, err)
+		}
+		if backfill, ok := backfills[version]; ok {
+			if err = backfill(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("Error backfilling migration %d: %v", version, err)
+			}
+		}
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareAll prepares each query into the statement it is keyed by,
+// closing the statements already prepared if any of them fail
+func prepareAll(db *sql.DB, queries map[**sql.Stmt]string) error {
+	prepared := make([]*sql.Stmt, 0, len(queries))
+	for stmt, query := range queries {
+		var err error
+		if *stmt, err = db.Prepare(query); err != nil {
+			closeAll(prepared...)
+			return err
+		}
+		prepared = append(prepared, *stmt)
+	}
+	return nil
+}
+
+// closeAll closes every statement that was prepared,
+// returning the first error encountered
+func closeAll(stmts ...*sql.Stmt) error {
+	var err error
+	for _, stmt := range stmts {
+		if stmt == nil {
+			continue
+		}
+		if cerr := stmt.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// New creates a new Storage backed by SQLite
+func New() (*sql.DB, error) {
+	// Transactions all write, so they take the write lock as they begin and
+	// wait for each other, rather than failing if another commits first
+	db, err := sql.Open("sqlite3", "./slack.db?_journal=WAL&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err = ensureSearchIndex(db); err != nil {
 		db.Close()
 		return nil, err
 	}
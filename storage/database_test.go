@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"database/sql"
+	"slack-backer-upper/slack"
+	"testing"
+)
+
+// TestSQLMicros checks that the migrations convert ts strings in the db
+// the same way new messages are converted on import
+func TestSQLMicros(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Opening db: %v", err)
+	}
+	defer db.Close()
+	query := `WITH t(ts) AS (SELECT ?) SELECT ` + sqlMicros("ts") + ` FROM t`
+	for _, ts := range []string{
+		"1588412758.000100",
+		"1588412758.1",
+		"1588412758.",
+		"1588412758",
+		"1588412758.1234567",
+		"0.000001",
+		"1234567890.999999",
+	} {
+		want, err := slack.TimestampMicros(ts)
+		if err != nil {
+			t.Errorf("TimestampMicros(%q) returned error: %v", ts, err)
+			continue
+		}
+		var got int64
+		if err = db.QueryRow(query, ts).Scan(&got); err != nil {
+			t.Errorf("sqlMicros(%q) returned error: %v", ts, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("sqlMicros(%q) = %d, TimestampMicros = %d", ts, got, want)
+		}
+	}
+}
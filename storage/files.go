@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slack-backer-upper/slack"
+)
+
+const insertAttachment = `
+	INSERT OR IGNORE INTO attachments
+		(channel, timestamp, position, title, url, fallback, attach_ts, extension, kind, ts_micros)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// backfillAttachments indexes the attachments of messages
+// archived before attachments were indexed on import
+func backfillAttachments(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT channel, timestamp, attachments, ts_micros FROM messages WHERE attachments NOT IN ("null", "[]")`)
+	if err != nil {
+		return err
+	}
+	type attached struct {
+		channel, timestamp string
+		micros             int64
+		attachments        []slack.Attachment
+	}
+	found := make([]attached, 0)
+	for rows.Next() {
+		var a attached
+		var attachJSON []byte
+		if err = rows.Scan(&a.channel, &a.timestamp, &attachJSON, &a.micros); err != nil {
+			rows.Close()
+			return err
+		}
+		if err = json.Unmarshal(attachJSON, &a.attachments); err != nil {
+			rows.Close()
+			return err
+		}
+		if len(a.attachments) > 0 {
+			found = append(found, a)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insertAttachment)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, a := range found {
+		if err = addAttachments(stmt, a.channel, a.timestamp, a.micros, a.attachments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addAttachments(stmt *sql.Stmt, channel, timestamp string, micros int64, attachments []slack.Attachment) error {
+	for i, attach := range attachments {
+		extension, kind := slack.AttachmentKind(attach)
+		if _, err := stmt.Exec(
+			channel, timestamp, i, attach.Title, attach.URL, attach.Fallback, attach.Timestamp, extension, kind, micros,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFiles gets the attachments and files of the messages matching q,
+// newest first, with the types in q
+func (d *ViewerDBHandle) GetFiles(q slack.FileQuery, limit, offset int) ([]slack.StoredFile, error) {
+	conditions := "f.ts_micros >= ?"
+	args := []interface{}{q.From}
+	if q.To != 0 {
+		conditions += " AND f.ts_micros < ?"
+		args = append(args, q.To)
+	}
+	if len(q.Channels) > 0 {
+		conditions += " AND f.channel IN (" + placeholders(len(q.Channels)) + ")"
+		for _, channel := range q.Channels {
+			args = append(args, channel)
+		}
+	}
+	if q.User != "" {
+		conditions += " AND (m.user_id = ? OR m.user_id IN (SELECT id FROM users WHERE real_name = ?))"
+		args = append(args, q.User, q.User)
+	}
+	if types := slack.NormalizeTypes(q.Types); len(types) > 0 {
+		conditions += " AND (f.kind IN (" + placeholders(len(types)) + ")"
+		conditions += " OR f.extension IN (" + placeholders(len(types)) + "))"
+		for _, t := range append(types, types...) {
+			args = append(args, t)
+		}
+	}
+	rows, err := d.db.Query(`
+		SELECT f.channel, f.timestamp, m.parent, m.user, f.title, f.url, f.fallback, f.attach_ts
+			FROM attachments f JOIN messages m ON m.channel = f.channel AND m.timestamp = f.timestamp
+			WHERE `+conditions+`
+			ORDER BY f.ts_micros DESC, f.channel, f.position LIMIT ? OFFSET ?;
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	files := make([]slack.StoredFile, 0, limit)
+	for rows.Next() {
+		var f slack.StoredFile
+		if err = rows.Scan(
+			&f.Channel, &f.Timestamp, &f.ParentTimestamp, &f.User, &f.Title, &f.URL, &f.Fallback, &f.Attachment.Timestamp,
+		); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
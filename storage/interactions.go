@@ -0,0 +1,48 @@
+package storage
+
+import "slack-backer-upper/slack"
+
+// GetInteractions counts the times each user replied to, @mentioned or
+// reacted to another user's messages, in the messages matching q.
+// Broadcasts to everyone in a channel are not counted as mentions.
+// Reactions are counted by the time of the message reacted to
+func (d *ViewerDBHandle) GetInteractions(q slack.StatsQuery) ([]slack.Interaction, error) {
+	conditions, args := statsFilters(q)
+	interactionArgs := make([]interface{}, 0, 3*len(args))
+	for i := 0; i < 3; i++ {
+		interactionArgs = append(interactionArgs, args...)
+	}
+	rows, err := d.db.Query(`
+		WITH interactions (source, target, kind) AS (
+			SELECT m.user, p.user, "reply" FROM messages m
+				JOIN messages p ON p.channel = m.channel AND p.timestamp = m.parent
+				WHERE `+conditions+`
+			UNION ALL
+			SELECT m.user, COALESCE(NULLIF(u.real_name, ""), x.user_id), "mention" FROM mentions x
+				JOIN messages m ON m.channel = x.channel AND m.timestamp = x.timestamp
+				LEFT JOIN users u ON u.id = x.user_id
+				WHERE `+conditions+` AND x.user_id NOT LIKE "!%"
+			UNION ALL
+			SELECT COALESCE(NULLIF(u.real_name, ""), ru.user_id), m.user, "reaction" FROM reaction_users ru
+				JOIN messages m ON m.channel = ru.channel AND m.timestamp = ru.timestamp
+				LEFT JOIN users u ON u.id = ru.user_id
+				WHERE `+conditions+`
+		)
+		SELECT source, target, kind, COUNT(*) FROM interactions
+			WHERE source != "" AND target != "" AND source != target
+			GROUP BY source, target, kind ORDER BY source, target, kind;
+	`, interactionArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	interactions := make([]slack.Interaction, 0, 256)
+	for rows.Next() {
+		var i slack.Interaction
+		if err = rows.Scan(&i.Source, &i.Target, &i.Kind, &i.Count); err != nil {
+			return nil, err
+		}
+		interactions = append(interactions, i)
+	}
+	return interactions, rows.Err()
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slack-backer-upper/slack"
+	"strings"
+)
+
+const insertLink = `
+	INSERT OR IGNORE INTO links (channel, timestamp, position, url, label, domain, user, ts_micros)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// backfillLinks indexes the links of messages
+// archived before links were indexed on import
+func backfillLinks(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT channel, timestamp, txt, attachments, user, ts_micros FROM messages WHERE has_link OR has_file")
+	if err != nil {
+		return err
+	}
+	type linked struct {
+		channel, timestamp, user string
+		micros                   int64
+		links                    []slack.Link
+	}
+	found := make([]linked, 0)
+	for rows.Next() {
+		var l linked
+		var text sql.NullString
+		var attachJSON []byte
+		var attachments []slack.Attachment
+		if err = rows.Scan(&l.channel, &l.timestamp, &text, &attachJSON, &l.user, &l.micros); err != nil {
+			rows.Close()
+			return err
+		}
+		if err = json.Unmarshal(attachJSON, &attachments); err != nil {
+			rows.Close()
+			return err
+		}
+		if l.links = slack.ExtractLinks(text.String, attachments); len(l.links) > 0 {
+			found = append(found, l)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insertLink)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, l := range found {
+		if err = addLinks(stmt, l.channel, l.timestamp, l.user, l.micros, l.links); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addLinks(stmt *sql.Stmt, channel, timestamp, user string, micros int64, links []slack.Link) error {
+	for i, link := range links {
+		if _, err := stmt.Exec(channel, timestamp, i, link.URL, link.Label, link.Domain, user, micros); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// likeEscaper escapes the wildcards in LIKE patterns, with \ as the escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// linkFilters turns a LinkQuery into SQL conditions on the links table aliased as l
+func linkFilters(q slack.LinkQuery) (string, []interface{}) {
+	conditions := "l.ts_micros >= ?"
+	args := []interface{}{q.From}
+	if q.To != 0 {
+		conditions += " AND l.ts_micros < ?"
+		args = append(args, q.To)
+	}
+	if q.Domain != "" {
+		conditions += ` AND (l.domain = ? OR l.domain LIKE "%." || ? ESCAPE "\")`
+		args = append(args, q.Domain, likeEscaper.Replace(q.Domain))
+	}
+	if len(q.Channels) > 0 {
+		conditions += " AND l.channel IN (" + placeholders(len(q.Channels)) + ")"
+		for _, channel := range q.Channels {
+			args = append(args, channel)
+		}
+	}
+	if q.User != "" {
+		conditions += ` AND (l.channel, l.timestamp) IN (
+			SELECT channel, timestamp FROM messages
+				WHERE user_id = ? OR user_id IN (SELECT id FROM users WHERE real_name = ?)
+		)`
+		args = append(args, q.User, q.User)
+	}
+	return conditions, args
+}
+
+// GetLinks gets the links matching q, newest first
+func (d *ViewerDBHandle) GetLinks(q slack.LinkQuery, limit, offset int) ([]slack.StoredLink, error) {
+	conditions, args := linkFilters(q)
+	rows, err := d.db.Query(`
+		SELECT l.channel, l.timestamp, l.user, l.url, l.label, l.domain FROM links l
+			WHERE `+conditions+`
+			ORDER BY l.ts_micros DESC, l.channel, l.position LIMIT ? OFFSET ?;
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := make([]slack.StoredLink, 0, limit)
+	for rows.Next() {
+		var l slack.StoredLink
+		if err = rows.Scan(&l.Channel, &l.Timestamp, &l.User, &l.URL, &l.Label, &l.Domain); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// GetTopLinks gets the links matching q shared the most times, counting
+// each URL once however many messages it was shared in. The label is
+// the most recent one the link was shared with
+func (d *ViewerDBHandle) GetTopLinks(q slack.LinkQuery, limit, offset int) ([]slack.SharedLink, error) {
+	conditions, args := linkFilters(q)
+	rows, err := d.db.Query(`
+		SELECT l.url, l.domain, COUNT(*), COUNT(DISTINCT l.channel), COUNT(DISTINCT l.user),
+				MIN(l.ts_micros), MAX(l.ts_micros), (
+					SELECT label FROM links r WHERE r.url = l.url AND r.label != ""
+						ORDER BY r.ts_micros DESC LIMIT 1
+				)
+			FROM links l WHERE `+conditions+`
+			GROUP BY l.url ORDER BY COUNT(*) DESC, MAX(l.ts_micros) DESC LIMIT ? OFFSET ?;
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := make([]slack.SharedLink, 0, limit)
+	for rows.Next() {
+		var l slack.SharedLink
+		var first, last int64
+		var label sql.NullString
+		if err = rows.Scan(
+			&l.URL, &l.Domain, &l.Shares, &l.Channels, &l.Users, &first, &last, &label,
+		); err != nil {
+			return nil, err
+		}
+		l.Label = label.String
+		l.FirstShared = uint64(first / 1e6)
+		l.LastShared = uint64(last / 1e6)
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
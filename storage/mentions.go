@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"database/sql"
+	"regexp"
+	"slack-backer-upper/slack"
+	"sort"
+	"strings"
+)
+
+const insertMention = "INSERT OR IGNORE INTO mentions (channel, timestamp, user_id) VALUES (?, ?, ?)"
+
+// backfillMentions indexes the @mentions of messages archived before
+// mentions were indexed on import. Those messages only have the display
+// names of the users they mention, so they are matched against the
+// display names of users in the archive, the longest first
+func backfillMentions(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, display_name FROM users WHERE display_name != ""`)
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]string)
+	names := make([]string, 0, 64)
+	for rows.Next() {
+		var id, name string
+		if err = rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := ids[name]; !ok {
+			ids[name] = id
+			names = append(names, regexp.QuoteMeta(name))
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil || len(names) == 0 {
+		return err
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	mention := regexp.MustCompile("@(" + strings.Join(names, "|") + ")")
+
+	rows, err = tx.Query(`SELECT channel, timestamp, txt FROM messages WHERE txt LIKE "%@%"`)
+	if err != nil {
+		return err
+	}
+	type mentioned struct {
+		channel, timestamp string
+		users              []string
+	}
+	found := make([]mentioned, 0)
+	for rows.Next() {
+		var m mentioned
+		var text string
+		if err = rows.Scan(&m.channel, &m.timestamp, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		for _, match := range mention.FindAllStringSubmatch(text, -1) {
+			m.users = append(m.users, ids[match[1]])
+		}
+		if len(m.users) > 0 {
+			found = append(found, m)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insertMention)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, m := range found {
+		if err = addMentions(stmt, m.channel, m.timestamp, m.users); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addMentions(stmt *sql.Stmt, channel, timestamp string, users []string) error {
+	for _, user := range users {
+		if _, err := stmt.Exec(channel, timestamp, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMentions gets the messages matching q that @mention a user, newest first,
+// with the parents of the threads they reply to. With broadcasts, it also gets
+// the !channel, !here and !everyone broadcasts in channels the user has posted in
+func (d *ViewerDBHandle) GetMentions(
+	userID string, q slack.StatsQuery, broadcasts bool, limit, offset int,
+) ([]slack.StoredMention, error) {
+	conditions, args := statsFilters(q)
+	mentioned := "x.user_id = ?"
+	mentionArgs := []interface{}{userID, userID}
+	if broadcasts {
+		mentioned = `(x.user_id = ? OR (x.user_id IN ("!channel", "!here", "!everyone") AND x.channel IN (
+			SELECT DISTINCT channel FROM messages WHERE user_id = ?
+		)))`
+		mentionArgs = append(mentionArgs, userID)
+	}
+	rows, err := d.db.Query(`
+		SELECT `+messageColumns("m")+`, MIN(CASE WHEN x.user_id = ? THEN "" ELSE x.user_id END)
+			FROM mentions x JOIN messages m ON m.channel = x.channel AND m.timestamp = x.timestamp
+			WHERE `+mentioned+` AND `+conditions+`
+			GROUP BY m.channel, m.timestamp
+			ORDER BY m.ts_micros DESC, m.channel LIMIT ? OFFSET ?;
+	`, append(append(mentionArgs, args...), limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := make([]slack.StoredMessage, 0, limit)
+	kinds := make([]string, 0, limit)
+	for rows.Next() {
+		var broadcast string
+		msg, err := scanMessage(rows, &broadcast)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+		kinds = append(kinds, broadcast)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	results, err := d.withThreadParents(messages, make([]string, len(messages)))
+	if err != nil {
+		return nil, err
+	}
+	mentions := make([]slack.StoredMention, len(results))
+	for i, result := range results {
+		mentions[i] = slack.StoredMention{Message: result.Message, Parent: result.Parent, Broadcast: kinds[i]}
+	}
+	return mentions, nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slack-backer-upper/slack"
+	"strings"
+)
+
+// ErrMessageNotFound is returned when a message is not in the archive
+var ErrMessageNotFound = errors.New("Message not found")
+
+// maxQueryVariables keeps queries with IN lists well under
+// SQLite's limit on the number of bound parameters
+const maxQueryVariables = 500
+
+// messageColumns are the columns of the messages table scanMessage reads,
+// qualified by the given table alias
+func messageColumns(alias string) string {
+	columns := []string{"channel", "timestamp", "txt", "user", "attachments", "reacts", "parent", "top_level", "subtype"}
+	for i, column := range columns {
+		columns[i] = alias + "." + column
+	}
+	return strings.Join(columns, ", ")
+}
+
+// scanMessage reads the messageColumns of a row, followed by any extra columns
+func scanMessage(rows *sql.Rows, extra ...interface{}) (slack.StoredMessage, error) {
+	var msg slack.StoredMessage
+	var attachJSON, reactsJSON []byte
+	dest := append([]interface{}{
+		&msg.Channel, &msg.Timestamp, &msg.Text, &msg.User, &attachJSON, &reactsJSON,
+		&msg.ParentTimestamp, &msg.DisplayTopLevel, &msg.Subtype,
+	}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return slack.StoredMessage{}, err
+	}
+	if err := json.Unmarshal(attachJSON, &msg.Attachments); err != nil {
+		return slack.StoredMessage{}, err
+	}
+	if err := json.Unmarshal(reactsJSON, &msg.Reacts); err != nil {
+		return slack.StoredMessage{}, err
+	}
+	return msg, nil
+}
+
+// placeholders returns a comma separated list of n SQL parameters
+func placeholders(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return "?" + strings.Repeat(", ?", n-1)
+}
+
+// chunks splits timestamps into groups small enough to bind in one query
+func chunks(timestamps []string) [][]string {
+	groups := make([][]string, 0, len(timestamps)/maxQueryVariables+1)
+	for len(timestamps) > maxQueryVariables {
+		groups = append(groups, timestamps[:maxQueryVariables])
+		timestamps = timestamps[maxQueryVariables:]
+	}
+	if len(timestamps) > 0 {
+		groups = append(groups, timestamps)
+	}
+	return groups
+}
+
+// loadMessages gets the messages in a channel with the given timestamps,
+// keyed by timestamp. Timestamps not in the archive are left out
+func (d *ViewerDBHandle) loadMessages(channel string, timestamps []string) (map[string]slack.StoredMessage, error) {
+	list := make([]slack.StoredMessage, 0, len(timestamps))
+	for _, group := range chunks(timestamps) {
+		args := []interface{}{channel}
+		for _, ts := range group {
+			args = append(args, ts)
+		}
+		rows, err := d.db.Query(`
+			SELECT `+messageColumns("m")+` FROM messages m
+				WHERE m.channel = ? AND m.timestamp IN (`+placeholders(len(group))+`);
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			msg, err := scanMessage(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			list = append(list, msg)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.addReactions(list); err != nil {
+		return nil, err
+	}
+	messages := make(map[string]slack.StoredMessage, len(list))
+	for _, msg := range list {
+		messages[msg.Timestamp] = msg
+	}
+	return messages, nil
+}
+
+// addReactions fills in the reactions to each message,
+// resolving the users who reacted to their current names
+func (d *ViewerDBHandle) addReactions(messages []slack.StoredMessage) error {
+	byChannel := make(map[string][]string)
+	for _, msg := range messages {
+		byChannel[msg.Channel] = append(byChannel[msg.Channel], msg.Timestamp)
+	}
+	reactions := make(map[[2]string][]slack.React)
+	for channel, timestamps := range byChannel {
+		for _, group := range chunks(timestamps) {
+			args := []interface{}{channel}
+			for _, ts := range group {
+				args = append(args, ts)
+			}
+			rows, err := d.db.Query(`
+				SELECT r.timestamp, r.name, r.count, COALESCE(NULLIF(u.real_name, ""), ru.user_id)
+					FROM reactions r
+					LEFT JOIN reaction_users ru
+						ON ru.channel = r.channel AND ru.timestamp = r.timestamp AND ru.name = r.name
+					LEFT JOIN users u ON u.id = ru.user_id
+					WHERE r.channel = ? AND r.timestamp IN (`+placeholders(len(group))+`)
+					ORDER BY r.rowid, ru.rowid;
+			`, args...)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var ts, name string
+				var count int
+				var user sql.NullString
+				if err = rows.Scan(&ts, &name, &count, &user); err != nil {
+					rows.Close()
+					return err
+				}
+				key := [2]string{channel, ts}
+				reacts := reactions[key]
+				if len(reacts) == 0 || reacts[len(reacts)-1].Name != name {
+					reacts = append(reacts, slack.React{Name: name, Count: count})
+				}
+				if user.Valid {
+					reacts[len(reacts)-1].Users = append(reacts[len(reacts)-1].Users, user.String)
+				}
+				reactions[key] = reacts
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[[2]string{messages[i].Channel, messages[i].Timestamp}]
+	}
+	return nil
+}
+
+// GetThreadTimestamp gets the timestamp of the thread a message is in,
+// which is the message's own timestamp if it is not a reply
+func (d *ViewerDBHandle) GetThreadTimestamp(channel, timestamp string) (string, error) {
+	var parent sql.NullString
+	err := d.db.QueryRow(
+		"SELECT parent FROM messages WHERE channel = ? AND timestamp = ?", channel, timestamp,
+	).Scan(&parent)
+	if err == sql.ErrNoRows {
+		return "", ErrMessageNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if parent.String != "" {
+		return parent.String, nil
+	}
+	return timestamp, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"database/sql"
+	"slack-backer-upper/slack"
+	"strings"
+	"time"
+)
+
+// GetOnThisDay gets the top level messages sent on the same month and day
+// as date in previous years, in date's location, ranked by the number of
+// reactions and replies they got. Messages without any are left out
+func (d *ViewerDBHandle) GetOnThisDay(
+	date time.Time, channels []string, limit int,
+) ([]slack.StoredNotableMessage, error) {
+	var first sql.NullInt64
+	if err := d.db.QueryRow("SELECT MIN(ts_micros) FROM messages").Scan(&first); err != nil {
+		return nil, err
+	}
+	if !first.Valid {
+		return []slack.StoredNotableMessage{}, nil
+	}
+	firstYear := time.Unix(0, first.Int64*1e3).In(date.Location()).Year()
+	days := make([]string, 0, 16)
+	args := make([]interface{}, 0, 32)
+	for year := date.Year() - 1; year >= firstYear; year-- {
+		if start, ok := slack.SameDay(date, year); ok {
+			days = append(days, "(m.ts_micros >= ? AND m.ts_micros < ?)")
+			args = append(args, start.UnixNano()/1e3, start.AddDate(0, 0, 1).UnixNano()/1e3)
+		}
+	}
+	if len(days) == 0 {
+		return []slack.StoredNotableMessage{}, nil
+	}
+	conditions, filterArgs := statsFilters(slack.StatsQuery{Channels: channels})
+	rows, err := d.db.Query(`
+		WITH notable AS (
+			SELECT `+messageColumns("m")+`, m.ts_micros, COALESCE((
+					SELECT SUM(x.count) FROM reactions x WHERE x.channel = m.channel AND x.timestamp = m.timestamp
+				), 0) AS reaction_count, MAX((
+					SELECT COUNT(*) FROM messages r WHERE r.channel = m.channel AND r.parent = m.timestamp
+				), COALESCE((
+					SELECT t.reply_count FROM threads t WHERE t.channel = m.channel AND t.timestamp = m.timestamp
+				), 0)) AS reply_total
+				FROM messages m
+				WHERE (`+strings.Join(days, " OR ")+`) AND `+conditions+` AND m.parent = ""
+		)
+		SELECT channel, timestamp, txt, user, attachments, reacts, parent, top_level, subtype,
+				reaction_count, reply_total
+			FROM notable WHERE reaction_count + reply_total > 0
+			ORDER BY reaction_count + reply_total DESC, ts_micros DESC LIMIT ?;
+	`, append(append(args, filterArgs...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notable := make([]slack.StoredNotableMessage, 0, limit)
+	messages := make([]slack.StoredMessage, 0, limit)
+	for rows.Next() {
+		var n slack.StoredNotableMessage
+		if n.Message, err = scanMessage(rows, &n.Reactions, &n.Replies); err != nil {
+			return nil, err
+		}
+		notable = append(notable, n)
+		messages = append(messages, n.Message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = d.addReactions(messages); err != nil {
+		return nil, err
+	}
+	for i := range notable {
+		notable[i].Message = messages[i]
+	}
+	return notable, nil
+}
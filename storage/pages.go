@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"fmt"
+	"slack-backer-upper/slack"
+	"strings"
+)
+
+// pageBounds makes the SQL conditions on the ts_micros and channel columns
+// for the time range and cursor of q, and the order the page is read in,
+// adding their arguments with param
+func pageBounds(q slack.PageQuery, param func(interface{}) string) ([]string, string) {
+	cursor, order := pageCursor(q, param)
+	return append(pageWindow(q, "ts_micros", param), cursor...), order
+}
+
+// pageWindow makes the SQL conditions on column for the time range of q
+func pageWindow(q slack.PageQuery, column string, param func(interface{}) string) []string {
+	conditions := []string{column + " >= " + param(q.From)}
+	if q.To != 0 {
+		conditions = append(conditions, column+" < "+param(q.To))
+	}
+	return conditions
+}
+
+// pageCursor makes the SQL conditions on the ts_micros and channel columns
+// for the cursor of q, and the order the page is read in
+func pageCursor(q slack.PageQuery, param func(interface{}) string) ([]string, string) {
+	var conditions []string
+	order := "ASC"
+	if q.NewestFirst {
+		order = "DESC"
+	}
+	if q.Cursor != nil {
+		comparison := ">"
+		if q.NewestFirst {
+			comparison = "<"
+		} else if q.IncludeCursor {
+			comparison = ">="
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"(ts_micros, channel) %s (%s, %s)", comparison, param(q.Cursor.Micros), param(q.Cursor.Channel),
+		))
+	}
+	return conditions, order
+}
+
+// GetParentPage gets a page of entries from the message lists of one or more
+// channels. Entries are parent messages, leaving out the hidden subtypes,
+// and the threads whose parent is missing from the archive. Like
+// GetParentMessages, parents from before the time range are included
+// if they have replies in it, and missing parents only if they do
+func (d *ViewerDBHandle) GetParentPage(q slack.PageQuery) (slack.StoredPage, error) {
+	args := make([]interface{}, 0, 8)
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("?%d", len(args))
+	}
+	conditions, order := pageCursor(q, param)
+	if len(q.Channels) > 0 {
+		numbered := make([]string, len(q.Channels))
+		for i, channel := range q.Channels {
+			numbered[i] = param(channel)
+		}
+		conditions = append(conditions, "channel IN ("+strings.Join(numbered, ", ")+")")
+	}
+	parentConditions := append([]string{"top_level = true", `parent = ""`}, conditions...)
+	if len(q.HiddenSubtypes) > 0 {
+		numbered := make([]string, len(q.HiddenSubtypes))
+		for i, subtype := range q.HiddenSubtypes {
+			numbered[i] = param(subtype)
+		}
+		parentConditions = append(parentConditions, "subtype NOT IN ("+strings.Join(numbered, ", ")+")")
+	}
+	selects := []string{`
+		SELECT channel, timestamp, ts_micros FROM messages
+			WHERE ` + strings.Join(append(parentConditions, pageWindow(q, "ts_micros", param)...), " AND ")}
+	orphanConditions := append([]string{"true"}, conditions...)
+	if q.From != 0 || q.To != 0 {
+		// Separate selects rather than an OR let each one use an index
+		repliesInWindow := `(channel, timestamp) IN (
+			SELECT r.channel, r.parent FROM messages r
+				WHERE r.parent != "" AND ` + strings.Join(pageWindow(q, "r.ts_micros", param), " AND ") + `
+		)`
+		selects = append(selects, `
+		SELECT channel, timestamp, ts_micros FROM messages
+			WHERE `+strings.Join(append(parentConditions, repliesInWindow), " AND "))
+		orphanConditions = append(orphanConditions, repliesInWindow)
+	}
+	selects = append(selects, `
+		SELECT channel, timestamp, ts_micros FROM orphan_threads
+			WHERE `+strings.Join(orphanConditions, " AND "))
+	rows, err := d.db.Query(strings.Join(selects, "\n\t\tUNION")+`
+		ORDER BY ts_micros `+order+`, channel `+order+` LIMIT `+param(q.Limit+1)+`;
+	`, args...)
+	if err != nil {
+		return slack.StoredPage{}, err
+	}
+	defer rows.Close()
+	var page slack.StoredPage
+	page.Entries = make([]slack.StoredPageEntry, 0, q.Limit+1)
+	for rows.Next() {
+		var entry slack.StoredPageEntry
+		if err = rows.Scan(&entry.Position.Channel, &entry.Timestamp, &entry.Position.Micros); err != nil {
+			return slack.StoredPage{}, err
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return slack.StoredPage{}, err
+	}
+	if len(page.Entries) > q.Limit {
+		page.Entries = page.Entries[:q.Limit]
+		page.More = true
+	}
+
+	timestamps := make(map[string][]string)
+	for _, entry := range page.Entries {
+		timestamps[entry.Position.Channel] = append(timestamps[entry.Position.Channel], entry.Timestamp)
+	}
+	parents := make(map[string]map[string]slack.StoredMessage, len(timestamps))
+	for channel, channelTimestamps := range timestamps {
+		if parents[channel], err = d.loadMessages(channel, channelTimestamps); err != nil {
+			return slack.StoredPage{}, err
+		}
+	}
+	for i, entry := range page.Entries {
+		if parent, ok := parents[entry.Position.Channel][entry.Timestamp]; ok {
+			page.Entries[i].Parent = &parent
+		}
+	}
+	return page, nil
+}
+
+// GetUserPage gets a page of the messages and replies a user sent
+// in one or more channels, leaving out the hidden subtypes
+func (d *ViewerDBHandle) GetUserPage(userID string, q slack.PageQuery) (slack.StoredPage, error) {
+	args := make([]interface{}, 0, 8)
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("?%d", len(args))
+	}
+	conditions, order := pageBounds(q, param)
+	conditions = append(conditions, "user_id = "+param(userID))
+	if len(q.Channels) > 0 {
+		numbered := make([]string, len(q.Channels))
+		for i, channel := range q.Channels {
+			numbered[i] = param(channel)
+		}
+		conditions = append(conditions, "channel IN ("+strings.Join(numbered, ", ")+")")
+	}
+	if len(q.HiddenSubtypes) > 0 {
+		numbered := make([]string, len(q.HiddenSubtypes))
+		for i, subtype := range q.HiddenSubtypes {
+			numbered[i] = param(subtype)
+		}
+		conditions = append(conditions, "subtype NOT IN ("+strings.Join(numbered, ", ")+")")
+	}
+	rows, err := d.db.Query(`
+		SELECT `+messageColumns("m")+`, m.ts_micros FROM messages m
+			WHERE `+strings.Join(conditions, " AND ")+`
+			ORDER BY m.ts_micros `+order+`, m.channel `+order+` LIMIT `+param(q.Limit+1)+`;
+	`, args...)
+	if err != nil {
+		return slack.StoredPage{}, err
+	}
+	defer rows.Close()
+	messages := make([]slack.StoredMessage, 0, q.Limit+1)
+	positions := make([]slack.PagePosition, 0, q.Limit+1)
+	for rows.Next() {
+		var micros int64
+		msg, err := scanMessage(rows, &micros)
+		if err != nil {
+			return slack.StoredPage{}, err
+		}
+		messages = append(messages, msg)
+		positions = append(positions, slack.PagePosition{Micros: micros, Channel: msg.Channel})
+	}
+	if err = rows.Err(); err != nil {
+		return slack.StoredPage{}, err
+	}
+	var page slack.StoredPage
+	if len(messages) > q.Limit {
+		messages = messages[:q.Limit]
+		page.More = true
+	}
+	if err = d.addReactions(messages); err != nil {
+		return slack.StoredPage{}, err
+	}
+	page.Entries = make([]slack.StoredPageEntry, len(messages))
+	for i := range messages {
+		page.Entries[i] = slack.StoredPageEntry{
+			Position: positions[i], Timestamp: messages[i].Timestamp, Parent: &messages[i],
+		}
+	}
+	return page, nil
+}
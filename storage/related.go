@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"slack-backer-upper/slack"
+	"sort"
+)
+
+// relatedTermsPerDocument is how many of the highest weighted terms of each
+// thread are kept in the index. Lower weighted terms contribute little to
+// similarity but would make up most of the index
+const relatedTermsPerDocument = 32
+
+// relatedDocument is a thread, or a message without replies,
+// whose text is compared to others to find related threads
+type relatedDocument struct {
+	channel, timestamp string
+	terms              map[string]int
+	replyCount         int
+	latestMicros       int64
+	participants       []string
+	// weights are the top terms of the thread, weighted by TF-IDF
+	weights []weightedTerm
+}
+
+type weightedTerm struct {
+	term   string
+	weight float64
+}
+
+// queryer is a DB or a transaction to read the documents from
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// BuildRelatedIndex rebuilds the index of related threads from every
+// message in the archive. Since adding messages changes how common every
+// term is, the whole index is rebuilt after each import. The threads are
+// weighted before starting the transaction, so other writers are only
+// blocked while the index is replaced
+func (d *ArchiveDBHandle) BuildRelatedIndex() error {
+	log.Print("Building related thread index...")
+	documents, err := weighRelatedDocuments(d.db)
+	if err != nil {
+		return err
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = writeRelatedIndex(tx, documents); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// buildRelatedIndex rebuilds the index of related threads in a migration
+func buildRelatedIndex(tx *sql.Tx) error {
+	documents, err := weighRelatedDocuments(tx)
+	if err != nil {
+		return err
+	}
+	return writeRelatedIndex(tx, documents)
+}
+
+// weighRelatedDocuments weights the terms of each thread by TF-IDF, keeping
+// the top terms of each thread as a unit vector so the similarity of two
+// threads is the sum of their shared terms' weights. The threads are read
+// twice, first to count how many threads use each term, so only the top
+// terms of each thread are held in memory
+func weighRelatedDocuments(q queryer) ([]relatedDocument, error) {
+	var count int
+	frequency := make(map[string]int)
+	if err := eachRelatedDocument(q, func(doc *relatedDocument) {
+		count++
+		for term := range doc.terms {
+			frequency[term]++
+		}
+	}); err != nil {
+		return nil, err
+	}
+	documents := make([]relatedDocument, 0, count)
+	err := eachRelatedDocument(q, func(doc *relatedDocument) {
+		weights := make([]weightedTerm, 0, len(doc.terms))
+		var norm float64
+		for term, n := range doc.terms {
+			idf := math.Log(float64(count) / float64(frequency[term]))
+			weight := (1 + math.Log(float64(n))) * idf
+			norm += weight * weight
+			// Terms only in this thread can't match any other
+			if frequency[term] > 1 && weight > 0 {
+				weights = append(weights, weightedTerm{term, weight})
+			}
+		}
+		if len(weights) == 0 {
+			return
+		}
+		sort.Slice(weights, func(i, j int) bool {
+			if weights[i].weight != weights[j].weight {
+				return weights[i].weight > weights[j].weight
+			}
+			return weights[i].term < weights[j].term
+		})
+		if len(weights) > relatedTermsPerDocument {
+			weights = weights[:relatedTermsPerDocument]
+		}
+		norm = math.Sqrt(norm)
+		for i := range weights {
+			weights[i].weight /= norm
+		}
+		doc.terms = nil
+		doc.weights = weights
+		documents = append(documents, *doc)
+	})
+	return documents, err
+}
+
+// writeRelatedIndex replaces the index of related threads with documents
+func writeRelatedIndex(tx *sql.Tx, documents []relatedDocument) error {
+	if _, err := tx.Exec("DELETE FROM related_documents; DELETE FROM related_terms;"); err != nil {
+		return err
+	}
+	addDocument, err := tx.Prepare(`
+		INSERT INTO related_documents (channel, timestamp, reply_count, latest_micros, participants)
+			VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer addDocument.Close()
+	addTerm, err := tx.Prepare("INSERT INTO related_terms (channel, timestamp, term, weight) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer addTerm.Close()
+	for _, doc := range documents {
+		participants, err := json.Marshal(doc.participants)
+		if err != nil {
+			return err
+		}
+		if _, err = addDocument.Exec(
+			doc.channel, doc.timestamp, doc.replyCount, doc.latestMicros, participants,
+		); err != nil {
+			return err
+		}
+		for _, w := range doc.weights {
+			if _, err = addTerm.Exec(doc.channel, doc.timestamp, w.term, w.weight); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eachRelatedDocument gathers the terms of each thread in turn,
+// leaving out system events
+func eachRelatedDocument(q queryer, fn func(*relatedDocument)) error {
+	rows, err := q.Query(`
+		SELECT channel, CASE WHEN COALESCE(parent, "") = "" THEN timestamp ELSE parent END AS thread,
+				parent, txt, user, ts_micros, subtype
+			FROM messages ORDER BY channel, thread, ts_micros;
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var doc *relatedDocument
+	for rows.Next() {
+		var channel, threadTimestamp string
+		var parent, text, user, subtype sql.NullString
+		var micros int64
+		if err = rows.Scan(&channel, &threadTimestamp, &parent, &text, &user, &micros, &subtype); err != nil {
+			return err
+		}
+		if slack.SubtypeCategory(subtype.String) != "" {
+			continue
+		}
+		if doc == nil || doc.channel != channel || doc.timestamp != threadTimestamp {
+			if doc != nil {
+				fn(doc)
+			}
+			doc = &relatedDocument{
+				channel: channel, timestamp: threadTimestamp, terms: make(map[string]int), participants: []string{},
+			}
+		}
+		for _, term := range slack.Terms(text.String) {
+			doc.terms[term]++
+		}
+		if parent.String == "" {
+			continue
+		}
+		doc.replyCount++
+		if micros > doc.latestMicros {
+			doc.latestMicros = micros
+		}
+		if user.String != "" && !contains(doc.participants, user.String) {
+			doc.participants = append(doc.participants, user.String)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if doc != nil {
+		fn(doc)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRelatedThreads finds the threads in any channel whose text is most
+// similar to the thread of the given message, most similar first
+func (d *ViewerDBHandle) GetRelatedThreads(
+	channel, timestamp string, limit int,
+) ([]slack.StoredRelatedThread, error) {
+	timestamp, err := d.GetThreadTimestamp(channel, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query(`
+		WITH source AS (
+			SELECT term, weight FROM related_terms WHERE channel = ?1 AND timestamp = ?2
+		), scores AS (
+			SELECT t.channel, t.timestamp, SUM(s.weight * t.weight) AS score
+				FROM source s
+				JOIN related_terms t ON t.term = s.term
+				WHERE NOT (t.channel = ?1 AND t.timestamp = ?2)
+				GROUP BY t.channel, t.timestamp
+		)
+		SELECT s.channel, s.timestamp, s.score, d.reply_count, d.latest_micros, d.participants
+			FROM scores s
+			JOIN related_documents d ON d.channel = s.channel AND d.timestamp = s.timestamp
+			ORDER BY s.score DESC, d.latest_micros DESC
+			LIMIT ?3;
+	`, channel, timestamp, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	related := make([]slack.StoredRelatedThread, 0, limit)
+	timestamps := make(map[string][]string)
+	for rows.Next() {
+		var r slack.StoredRelatedThread
+		var participantsJSON []byte
+		if err = rows.Scan(
+			&r.Channel, &r.Thread.Timestamp, &r.Score, &r.Thread.ReplyCount, &r.Thread.LatestReply, &participantsJSON,
+		); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(participantsJSON, &r.Thread.Participants); err != nil {
+			return nil, err
+		}
+		related = append(related, r)
+		timestamps[r.Channel] = append(timestamps[r.Channel], r.Thread.Timestamp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	parents := make(map[string]map[string]slack.StoredMessage, len(timestamps))
+	for channel, channelTimestamps := range timestamps {
+		if parents[channel], err = d.loadMessages(channel, channelTimestamps); err != nil {
+			return nil, err
+		}
+	}
+	for i := range related {
+		if parent, ok := parents[related[i].Channel][related[i].Thread.Timestamp]; ok {
+			related[i].Thread.Parent = &parent
+		}
+	}
+	return related, nil
+}
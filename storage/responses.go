@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"regexp"
+	"slack-backer-upper/slack"
+	"time"
+)
+
+// GetThreadReplyTimes gets the times of the replies to each thread started by
+// a message matching q, leaving out replies from the user who started it
+func (d *ViewerDBHandle) GetThreadReplyTimes(q slack.StatsQuery) ([]slack.ThreadReplies, error) {
+	conditions, args := statsFilters(q)
+	rows, err := d.db.Query(`
+		SELECT m.channel, m.timestamp, m.ts_micros, r.user, r.ts_micros
+			FROM messages m
+			JOIN messages r ON r.channel = m.channel AND r.parent = m.timestamp AND r.user != m.user
+			WHERE `+conditions+` AND m.parent = ""
+			ORDER BY m.channel, m.ts_micros, m.timestamp, r.ts_micros;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	threads := make([]slack.ThreadReplies, 0, 256)
+	var lastTimestamp string
+	for rows.Next() {
+		var channel, timestamp string
+		var parentMicros int64
+		var reply slack.ReplyTime
+		if err = rows.Scan(&channel, &timestamp, &parentMicros, &reply.User, &reply.Micros); err != nil {
+			return nil, err
+		}
+		if len(threads) == 0 || threads[len(threads)-1].Channel != channel || lastTimestamp != timestamp {
+			threads = append(threads, slack.ThreadReplies{Channel: channel, ParentMicros: parentMicros})
+			lastTimestamp = timestamp
+		}
+		thread := &threads[len(threads)-1]
+		thread.Replies = append(thread.Replies, reply)
+	}
+	return threads, rows.Err()
+}
+
+// GetUnansweredQuestions gets the top level messages matching q and pattern,
+// newest first, that no other user replied to within the given time or
+// reacted to at all. Exports don't say when reactions were added, so they
+// can't be time limited. Messages sent less than that long before the last
+// message in the archive are left out, since their replies may not have
+// been archived yet
+func (d *ViewerDBHandle) GetUnansweredQuestions(
+	q slack.StatsQuery, pattern *regexp.Regexp, within time.Duration, limit, offset int,
+) ([]slack.StoredMessage, error) {
+	conditions, args := statsFilters(q)
+	window := within.Microseconds()
+	args = append(args, window, window)
+	rows, err := d.db.Query(`
+		SELECT `+messageColumns("m")+` FROM messages m
+			WHERE `+conditions+` AND m.parent = "" AND m.txt != ""
+				AND m.ts_micros + ? <= (SELECT MAX(ts_micros) FROM messages)
+				AND NOT EXISTS (
+					SELECT 1 FROM messages r
+						WHERE r.channel = m.channel AND r.parent = m.timestamp
+							AND r.user != m.user AND r.ts_micros < m.ts_micros + ?
+				)
+				AND NOT EXISTS (
+					SELECT 1 FROM reaction_users x
+						WHERE x.channel = m.channel AND x.timestamp = m.timestamp AND x.user_id != m.user_id
+				)
+			ORDER BY m.ts_micros DESC;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := make([]slack.StoredMessage, 0, limit)
+	for len(messages) < limit && rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !pattern.MatchString(msg.Text) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"slack-backer-upper/slack"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrSearchUnavailable is returned by full-text searches when
+// SQLite was built without FTS5, i.e. without the sqlite_fts5 build tag
+var ErrSearchUnavailable = errors.New("Full-text search is unavailable: rebuild with -tags sqlite_fts5")
+
+// ensureSearchIndex creates the full-text index of message text if
+// SQLite supports it, filling it from the messages already archived.
+// The index is not part of the migrations since FTS5 depends on how
+// the binary was built, and a build without it should still work
+func ensureSearchIndex(db *sql.DB) error {
+	if searchIndexExists(db) {
+		if !searchAvailable(db) {
+			log.Print(ErrSearchUnavailable)
+			return nil
+		}
+		return syncSearchIndex(db)
+	}
+	if _, err := db.Exec(`
+		CREATE VIRTUAL TABLE messages_fts USING fts5(
+			txt, channel UNINDEXED, timestamp UNINDEXED, tokenize = "unicode61"
+		);
+	`); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			log.Print(ErrSearchUnavailable)
+			return nil
+		}
+		return err
+	}
+	log.Print("Building full-text search index...")
+	_, err := db.Exec("INSERT INTO messages_fts (txt, channel, timestamp) SELECT txt, channel, timestamp FROM messages")
+	return err
+}
+
+// syncSearchIndex rebuilds the full-text index if it is missing messages,
+// which happens when a build without FTS5 imported them
+func syncSearchIndex(db *sql.DB) error {
+	var indexed, archived int
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages_fts").Scan(&indexed); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&archived); err != nil {
+		return err
+	}
+	if indexed == archived {
+		return nil
+	}
+	log.Print("Rebuilding full-text search index...")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM messages_fts"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(
+		"INSERT INTO messages_fts (txt, channel, timestamp) SELECT txt, channel, timestamp FROM messages",
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func searchIndexExists(db *sql.DB) bool {
+	var name string
+	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = "table" AND name = "messages_fts"`).Scan(&name)
+	return err == nil
+}
+
+// searchAvailable checks whether the full-text index exists and can be
+// used. A DB created by a build with FTS5 has the index, but a build
+// without FTS5 can neither read nor write it
+func searchAvailable(db *sql.DB) bool {
+	rows, err := db.Query("SELECT rowid FROM messages_fts LIMIT 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// ftsPhrase quotes text as a single FTS5 phrase, keeping a trailing *
+// outside of the quotes so it still matches as a prefix
+func ftsPhrase(text string) string {
+	prefix := strings.HasSuffix(text, "*")
+	text = strings.TrimSuffix(text, "*")
+	phrase := `"` + strings.Replace(text, `"`, `""`, -1) + `"`
+	if prefix {
+		phrase += "*"
+	}
+	return phrase
+}
+
+// ftsMatch builds an FTS5 expression requiring every term and phrase
+func ftsMatch(q slack.SearchQuery) string {
+	parts := make([]string, 0, len(q.Terms)+len(q.Phrases))
+	for _, term := range q.Terms {
+		parts = append(parts, ftsPhrase(term))
+	}
+	for _, phrase := range q.Phrases {
+		parts = append(parts, ftsPhrase(phrase))
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// ftsAny builds an FTS5 expression matching any of the texts
+func ftsAny(texts []string) string {
+	parts := make([]string, len(texts))
+	for i, text := range texts {
+		parts[i] = ftsPhrase(text)
+	}
+	return strings.Join(parts, " OR ")
+}
+
+// searchFilters turns the modifiers of a query into SQL conditions
+// on the messages table aliased as m, along with their arguments
+func searchFilters(q slack.SearchQuery) ([]string, []interface{}) {
+	conditions := make([]string, 0, 8)
+	args := make([]interface{}, 0, 8)
+	if len(q.From) > 0 {
+		ors := make([]string, len(q.From))
+		for i, from := range q.From {
+			ors[i] = `m.user = ? COLLATE NOCASE OR m.user IN (
+				SELECT real_name FROM users
+					WHERE id = ? OR display_name = ? COLLATE NOCASE OR real_name = ? COLLATE NOCASE
+			)`
+			args = append(args, from, from, from, from)
+		}
+		conditions = append(conditions, "("+strings.Join(ors, " OR ")+")")
+	}
+	if len(q.In) > 0 {
+		conditions = append(conditions, "m.channel IN ("+placeholders(len(q.In))+")")
+		for _, channel := range q.In {
+			args = append(args, channel)
+		}
+	}
+	if !q.After.IsZero() {
+		conditions = append(conditions, "m.ts_micros >= ?")
+		args = append(args, q.After.UnixNano()/1e3)
+	}
+	if !q.Before.IsZero() {
+		conditions = append(conditions, "m.ts_micros < ?")
+		args = append(args, q.Before.UnixNano()/1e3)
+	}
+	if q.HasLink {
+		conditions = append(conditions, "m.has_link")
+	}
+	if q.HasFile {
+		conditions = append(conditions, "m.has_file")
+	}
+	if q.IsThread {
+		conditions = append(conditions, `(m.parent != "" OR EXISTS (
+			SELECT 1 FROM messages r WHERE r.channel = m.channel AND r.parent = m.timestamp
+		))`)
+	}
+	if len(q.Languages) > 0 {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM code_blocks c
+				WHERE c.channel = m.channel AND c.timestamp = m.timestamp AND c.language IN (`+
+			placeholders(len(q.Languages))+`)
+		)`)
+		for _, language := range q.Languages {
+			args = append(args, language)
+		}
+	}
+	return conditions, args
+}
+
+// Search finds the messages matching a query, best matches first,
+// or most recent first if the query only has modifiers.
+// Replies come with the parent message of their thread
+func (d *ViewerDBHandle) Search(q slack.SearchQuery, limit, offset int) ([]slack.StoredSearchResult, error) {
+	if !searchAvailable(d.db) {
+		return nil, ErrSearchUnavailable
+	}
+	conditions, filterArgs := searchFilters(q)
+	if len(q.Excluded) > 0 {
+		conditions = append(conditions, `(m.channel, m.timestamp) NOT IN (
+			SELECT channel, timestamp FROM messages_fts WHERE messages_fts MATCH ?
+		)`)
+		filterArgs = append(filterArgs, ftsAny(q.Excluded))
+	}
+	var query string
+	var args []interface{}
+	if q.HasText() {
+		query = `
+			SELECT ` + messageColumns("m") + `,
+					snippet(messages_fts, 0, ?, ?, "…", 16)
+				FROM messages_fts f
+				JOIN messages m ON m.channel = f.channel AND m.timestamp = f.timestamp
+				WHERE messages_fts MATCH ?`
+		args = append(args, slack.SnippetMatchStart, slack.SnippetMatchEnd, ftsMatch(q))
+	} else {
+		query = `
+			SELECT ` + messageColumns("m") + `, "" FROM messages m WHERE true`
+	}
+	for _, condition := range conditions {
+		query += " AND " + condition
+	}
+	args = append(args, filterArgs...)
+	if q.HasText() {
+		query += " ORDER BY bm25(messages_fts), m.ts_micros DESC"
+	} else {
+		query += " ORDER BY m.ts_micros DESC"
+	}
+	query += " LIMIT ? OFFSET ?;"
+	args = append(args, limit, offset)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := make([]slack.StoredMessage, 0, limit)
+	snippets := make([]string, 0, limit)
+	for rows.Next() {
+		var snippet string
+		msg, err := scanMessage(rows, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+		snippets = append(snippets, snippet)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return d.withThreadParents(messages, snippets)
+}
+
+// withThreadParents pairs each message with its snippet
+// and, if it is a reply, with the parent message of its thread
+func (d *ViewerDBHandle) withThreadParents(
+	messages []slack.StoredMessage, snippets []string,
+) ([]slack.StoredSearchResult, error) {
+	if err := d.addReactions(messages); err != nil {
+		return nil, err
+	}
+	parentTimestamps := make(map[string][]string)
+	for _, msg := range messages {
+		if msg.ParentTimestamp != "" {
+			parentTimestamps[msg.Channel] = append(parentTimestamps[msg.Channel], msg.ParentTimestamp)
+		}
+	}
+	parents := make(map[string]map[string]slack.StoredMessage, len(parentTimestamps))
+	for channel, timestamps := range parentTimestamps {
+		loaded, err := d.loadMessages(channel, timestamps)
+		if err != nil {
+			return nil, err
+		}
+		parents[channel] = loaded
+	}
+	results := make([]slack.StoredSearchResult, len(messages))
+	for i, msg := range messages {
+		results[i] = slack.StoredSearchResult{Message: msg, Snippet: snippets[i]}
+		if parent, ok := parents[msg.Channel][msg.ParentTimestamp]; ok {
+			results[i].Parent = &parent
+		}
+	}
+	return results, nil
+}
+
+// snippetContext is how many bytes of text either side of a
+// regular expression match are kept in its snippet
+const snippetContext = 60
+
+// RegexSearch scans messages matching the modifiers of q, most recent first,
+// for text matching pattern. The scan stops once limit messages match or the
+// timeout passes, in which case the matches found so far are returned and
+// complete is false
+func (d *ViewerDBHandle) RegexSearch(
+	pattern *regexp.Regexp, q slack.SearchQuery, limit int, timeout time.Duration,
+) (results []slack.StoredSearchResult, complete bool, err error) {
+	conditions, args := searchFilters(q)
+	query := `SELECT ` + messageColumns("m") + ` FROM messages m WHERE m.txt != ""`
+	for _, condition := range conditions {
+		query += " AND " + condition
+	}
+	query += " ORDER BY m.ts_micros DESC;"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if ctx.Err() != nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	messages := make([]slack.StoredMessage, 0, limit)
+	snippets := make([]string, 0, limit)
+	complete = true
+	for len(messages) < limit {
+		if !rows.Next() {
+			break
+		}
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		if match := pattern.FindStringIndex(msg.Text); match != nil {
+			messages = append(messages, msg)
+			snippets = append(snippets, regexSnippet(msg.Text, match))
+		}
+	}
+	if err = rows.Err(); ctx.Err() != nil {
+		complete = false
+	} else if err != nil {
+		return nil, false, err
+	}
+	results, err = d.withThreadParents(messages, snippets)
+	return results, complete, err
+}
+
+// regexSnippet marks the match in text, like the snippets of
+// full-text search, trimming the text around it
+func regexSnippet(text string, match []int) string {
+	start, end := match[0]-snippetContext, match[1]+snippetContext
+	prefix, suffix := "…", "…"
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	if end >= len(text) {
+		end, suffix = len(text), ""
+	}
+	// Avoid cutting a multi-byte character in half
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	return prefix + text[start:match[0]] + slack.SnippetMatchStart + text[match[0]:match[1]] +
+		slack.SnippetMatchEnd + text[match[1]:end] + suffix
+}
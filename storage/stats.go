@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"slack-backer-upper/slack"
+)
+
+// statsFilters turns a StatsQuery into SQL conditions on the messages table
+// aliased as m, leaving out system events such as channel joins
+func statsFilters(q slack.StatsQuery) (string, []interface{}) {
+	system := slack.SubtypesInCategories(slack.SystemCategories())
+	conditions := "m.subtype NOT IN (" + placeholders(len(system)) + ") AND m.ts_micros >= ?"
+	args := make([]interface{}, 0, len(system)+len(q.Channels)+2)
+	for _, subtype := range system {
+		args = append(args, subtype)
+	}
+	args = append(args, q.From)
+	if q.To != 0 {
+		conditions += " AND m.ts_micros < ?"
+		args = append(args, q.To)
+	}
+	if len(q.Channels) > 0 {
+		conditions += " AND m.channel IN (" + placeholders(len(q.Channels)) + ")"
+		for _, channel := range q.Channels {
+			args = append(args, channel)
+		}
+	}
+	return conditions, args
+}
+
+// startsThread is an SQL condition for a message in m having started a thread,
+// whether or not its replies are in the archive
+const startsThread = `m.parent = "" AND (
+	EXISTS (SELECT 1 FROM messages r WHERE r.channel = m.channel AND r.parent = m.timestamp)
+	OR EXISTS (SELECT 1 FROM threads t WHERE t.channel = m.channel AND t.timestamp = m.timestamp)
+)`
+
+// GetChannelStats counts the messages in each channel, busiest first
+func (d *ViewerDBHandle) GetChannelStats(q slack.StatsQuery) ([]slack.ChannelStats, error) {
+	conditions, args := statsFilters(q)
+	rows, err := d.db.Query(`
+		SELECT m.channel, COUNT(*), SUM(m.parent != ""), SUM(`+startsThread+`), SUM(m.parent = ""),
+				COUNT(DISTINCT m.user), MIN(m.ts_micros), MAX(m.ts_micros)
+			FROM messages m WHERE `+conditions+`
+			GROUP BY m.channel ORDER BY COUNT(*) DESC, m.channel;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make([]slack.ChannelStats, 0, 16)
+	for rows.Next() {
+		var s slack.ChannelStats
+		var parents int
+		var first, last int64
+		if err = rows.Scan(
+			&s.Channel, &s.Messages, &s.Replies, &s.Threads, &parents, &s.Users, &first, &last,
+		); err != nil {
+			return nil, err
+		}
+		if parents > 0 {
+			s.ThreadRatio = float64(s.Threads) / float64(parents)
+		}
+		s.FirstActivity = uint64(first / 1e6)
+		s.LastActivity = uint64(last / 1e6)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetUserStats counts the messages sent by each user, most active first
+func (d *ViewerDBHandle) GetUserStats(q slack.StatsQuery) ([]slack.UserStats, error) {
+	conditions, args := statsFilters(q)
+	rows, err := d.db.Query(`
+		SELECT m.user, COUNT(*), SUM(m.parent != ""), SUM(`+startsThread+`),
+				COUNT(DISTINCT m.channel), MIN(m.ts_micros), MAX(m.ts_micros)
+			FROM messages m WHERE `+conditions+` AND m.user != ""
+			GROUP BY m.user ORDER BY COUNT(*) DESC, m.user;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make([]slack.UserStats, 0, 64)
+	for rows.Next() {
+		var s slack.UserStats
+		var first, last int64
+		if err = rows.Scan(
+			&s.User, &s.Messages, &s.Replies, &s.ThreadsStarted, &s.Channels, &first, &last,
+		); err != nil {
+			return nil, err
+		}
+		s.FirstActivity = uint64(first / 1e6)
+		s.LastActivity = uint64(last / 1e6)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetMessageCounts counts the messages matching q sent in each quarter hour,
+// oldest first, for counting them by periods of local time. Only quarter
+// hours with messages are included
+func (d *ViewerDBHandle) GetMessageCounts(q slack.StatsQuery) ([]slack.TimeCount, error) {
+	conditions, args := statsFilters(q)
+	rows, err := d.db.Query(`
+		SELECT m.ts_micros - m.ts_micros % ? AS slot, COUNT(*) FROM messages m
+			WHERE `+conditions+` GROUP BY slot ORDER BY slot;
+	`, append([]interface{}{slack.QuarterHour}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make([]slack.TimeCount, 0, 1024)
+	for rows.Next() {
+		var count slack.TimeCount
+		if err = rows.Scan(&count.Micros, &count.Messages); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// GetMessageTexts gets the text of every message matching q, oldest first
+func (d *ViewerDBHandle) GetMessageTexts(q slack.StatsQuery) ([]slack.TimedText, error) {
+	conditions, args := statsFilters(q)
+	rows, err := d.db.Query(`
+		SELECT m.channel, m.ts_micros, m.txt FROM messages m
+			WHERE `+conditions+` AND m.txt != "" ORDER BY m.ts_micros;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	texts := make([]slack.TimedText, 0, 1024)
+	for rows.Next() {
+		var text slack.TimedText
+		if err = rows.Scan(&text.Channel, &text.Micros, &text.Text); err != nil {
+			return nil, err
+		}
+		texts = append(texts, text)
+	}
+	return texts, rows.Err()
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"slack-backer-upper/slack"
+	"strings"
+)
+
+// GetThreadSummaries gets the summaries of the threads started by the given
+// messages in a channel, keyed by the timestamp of the thread. Summaries are
+// made the first time they are asked for and kept until the thread changes.
+// Threads with no messages in the archive are left out
+func (d *ViewerDBHandle) GetThreadSummaries(channel string, timestamps []string) (map[string]slack.Summary, error) {
+	summaries := make(map[string]slack.Summary, len(timestamps))
+	var missing []string
+	for _, group := range chunks(timestamps) {
+		args := []interface{}{channel}
+		for _, ts := range group {
+			args = append(args, ts)
+		}
+		rows, err := d.db.Query(`
+			SELECT timestamp, summary FROM thread_summaries
+				WHERE channel = ? AND timestamp IN (`+placeholders(len(group))+`);
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var ts string
+			var summaryJSON []byte
+			var summary slack.Summary
+			if err = rows.Scan(&ts, &summaryJSON); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if err = json.Unmarshal(summaryJSON, &summary); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			summaries[ts] = summary
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, ts := range timestamps {
+		if _, ok := summaries[ts]; !ok {
+			missing = append(missing, ts)
+		}
+	}
+	if len(missing) == 0 {
+		return summaries, nil
+	}
+	threads, err := d.getSummaryMessages(channel, missing)
+	if err != nil {
+		return nil, err
+	}
+	for ts, messages := range threads {
+		summary := slack.Summarize(messages)
+		summaryJSON, err := json.Marshal(summary)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = d.db.Exec(
+			"INSERT OR REPLACE INTO thread_summaries (channel, timestamp, summary) VALUES (?, ?, ?)",
+			channel, ts, summaryJSON,
+		); err != nil {
+			return nil, err
+		}
+		summaries[ts] = summary
+	}
+	return summaries, nil
+}
+
+// getSummaryMessages gets the parent and replies of each thread to be
+// summarised, leaving out system events, keyed by the timestamp of the thread
+func (d *ViewerDBHandle) getSummaryMessages(
+	channel string, timestamps []string,
+) (map[string][]slack.SummaryMessage, error) {
+	system := slack.SubtypesInCategories(slack.SystemCategories())
+	threads := make(map[string][]slack.SummaryMessage, len(timestamps))
+	for _, group := range chunks(timestamps) {
+		args := make([]interface{}, 0, len(group)+len(system)+1)
+		param := func(value interface{}) string {
+			args = append(args, value)
+			return fmt.Sprintf("?%d", len(args))
+		}
+		channelParam := param(channel)
+		numbered := make([]string, len(group))
+		for i, ts := range group {
+			numbered[i] = param(ts)
+		}
+		list := strings.Join(numbered, ", ")
+		numbered = make([]string, len(system))
+		for i, subtype := range system {
+			numbered[i] = param(subtype)
+		}
+		rows, err := d.db.Query(`
+			SELECT CASE WHEN m.parent = "" THEN m.timestamp ELSE m.parent END, m.timestamp, m.txt, m.user,
+					COALESCE((
+						SELECT SUM(r.count) FROM reactions r WHERE r.channel = m.channel AND r.timestamp = m.timestamp
+					), 0)
+				FROM messages m
+				WHERE m.channel = `+channelParam+` AND (m.timestamp IN (`+list+`) OR m.parent IN (`+list+`))
+					AND m.subtype NOT IN (`+strings.Join(numbered, ", ")+`)
+				ORDER BY m.ts_micros;
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var thread string
+			var msg slack.SummaryMessage
+			if err = rows.Scan(&thread, &msg.TS, &msg.Text, &msg.User, &msg.Reactions); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			threads[thread] = append(threads[thread], msg)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return threads, nil
+}
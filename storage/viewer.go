@@ -3,53 +3,88 @@ package storage
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"slack-backer-upper/slack"
-	"strconv"
-	"strings"
 	"time"
 )
 
+// ErrChannelNotFound is returned when a channel ID is not in the archive
+var ErrChannelNotFound = errors.New("Channel not found")
+
 // ViewerDBHandle is a handle to the database plus resources
 // needed to handle getting information from it
 type ViewerDBHandle struct {
-	db          *sql.DB
-	getMessages *sql.Stmt
-	getReplies  *sql.Stmt
+	db              *sql.DB
+	getChannel      *sql.Stmt
+	getChannelEvent *sql.Stmt
+	getPins         *sql.Stmt
+	getBookmarks    *sql.Stmt
+	getOrphans      *sql.Stmt
+	getThreads      *sql.Stmt
+	getReacted      *sql.Stmt
 }
 
 // Close closes resources specific to the ViewerDBHandle
 // but not the underlying DB itself
 func (d *ViewerDBHandle) Close() error {
-	if err := d.getMessages.Close(); err != nil {
-		return err
-	}
-	return d.getReplies.Close()
+	return closeAll(d.getChannel, d.getChannelEvent, d.getPins, d.getBookmarks,
+		d.getOrphans, d.getThreads, d.getReacted,
+	)
 }
 
 // Viewer creates and returns a handle to the initialized database,
 // creates the necessary tables, and prepares the necessary statements
 func Viewer(db *sql.DB) (*ViewerDBHandle, error) {
-	getMessages, err := db.Prepare(`
-		SELECT timestamp, txt, user, attachments, reacts FROM messages
-			WHERE channel = ? AND timestamp >= ? AND timestamp < ? AND top_level = true AND parent = ""
-			ORDER BY timestamp;
-	`)
-	if err != nil {
+	d := &ViewerDBHandle{db: db}
+	if err := prepareAll(db, map[**sql.Stmt]string{
+		&d.getChannel: `
+			SELECT id, name, created, creator, archived, topic, purpose FROM channels WHERE name = ?;
+		`,
+		&d.getChannelEvent: `
+			SELECT timestamp, field, value, user FROM channel_history WHERE channel = ? ORDER BY timestamp;
+		`,
+		&d.getPins: `
+			SELECT p.timestamp, p.pinned_by, p.pinned_at
+				FROM pins p LEFT JOIN messages m ON m.channel = p.channel AND m.timestamp = p.timestamp
+				WHERE p.channel = ? ORDER BY p.pinned_at DESC, m.ts_micros DESC;
+		`,
+		&d.getBookmarks: `
+			SELECT id, title, link, emoji, created, creator FROM bookmarks
+				WHERE channel = ? ORDER BY created, title;
+		`,
+		&d.getOrphans: `
+			SELECT DISTINCT parent FROM messages
+				WHERE channel = ?1 AND parent != "" AND ts_micros >= ?2 AND ts_micros < ?3
+					AND parent NOT IN (SELECT timestamp FROM messages WHERE channel = ?1);
+		`,
+		&d.getThreads: `
+			WITH replies AS (
+				SELECT parent, COUNT(*) AS n, MAX(ts_micros) AS latest FROM messages
+					WHERE channel = ?1 AND parent != "" GROUP BY parent
+			), heads AS (
+				SELECT parent AS timestamp, n, latest FROM replies
+				UNION ALL
+				SELECT timestamp, 0, 0 FROM threads
+					WHERE channel = ?1 AND timestamp NOT IN (SELECT parent FROM replies)
+			)
+			SELECT h.timestamp, h.n, h.latest, t.reply_count, t.reply_users, t.latest_micros
+				FROM heads h
+				LEFT JOIN threads t ON t.channel = ?1 AND t.timestamp = h.timestamp
+				ORDER BY MAX(h.latest, COALESCE(t.latest_micros, 0)) DESC
+				LIMIT ?2 OFFSET ?3;
+		`,
+		&d.getReacted: `
+			SELECT ` + messageColumns("m") + ` FROM (
+					SELECT DISTINCT channel, timestamp FROM reaction_users
+						WHERE user_id = ?1 AND emoji = ?2 AND (?3 = "" OR channel = ?3)
+				) r
+				JOIN messages m ON m.channel = r.channel AND m.timestamp = r.timestamp
+				ORDER BY m.ts_micros DESC LIMIT ?4;
+		`,
+	}); err != nil {
 		return nil, err
 	}
-	getReplies, err := db.Prepare(`
-		SELECT timestamp, txt, user, attachments, reacts, top_level FROM messages
-			WHERE channel = ? AND parent = ? ORDER BY timestamp;
-	`)
-	if err != nil {
-		getMessages.Close()
-		return nil, err
-	}
-	return &ViewerDBHandle{
-		db:          db,
-		getMessages: getMessages,
-		getReplies:  getReplies,
-	}, err
+	return d, nil
 }
 
 // GetChannels enumerates the channels in the storage
@@ -70,70 +105,383 @@ func (d *ViewerDBHandle) GetChannels() ([]string, error) {
 	return channels, nil
 }
 
+// GetChannelName gets the name of the channel with a Slack channel ID.
+// Only channels imported from a channels.json have their ID
+func (d *ViewerDBHandle) GetChannelName(id string) (string, error) {
+	var name string
+	err := d.db.QueryRow("SELECT name FROM channels WHERE id = ?", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", ErrChannelNotFound
+	}
+	return name, err
+}
+
+// GetChannel gets a channel's metadata and the history of changes to it.
+// Channels imported without a channels.json only have their name filled in
+func (d *ViewerDBHandle) GetChannel(name string) (slack.Channel, error) {
+	channel := slack.Channel{Name: name}
+	var id, creator, topic, purpose sql.NullString
+	var created sql.NullInt64
+	var archived sql.NullBool
+	err := d.getChannel.QueryRow(name).Scan(&id, &channel.Name, &created, &creator, &archived, &topic, &purpose)
+	if err != nil && err != sql.ErrNoRows {
+		return slack.Channel{}, err
+	}
+	channel.ID = id.String
+	channel.Created = created.Int64
+	channel.Creator = creator.String
+	channel.Archived = archived.Bool
+	channel.Topic = topic.String
+	channel.Purpose = purpose.String
+	rows, err := d.getChannelEvent.Query(name)
+	if err != nil {
+		return slack.Channel{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event slack.ChannelEvent
+		var value, user sql.NullString
+		if err = rows.Scan(&event.Timestamp, &event.Field, &value, &user); err != nil {
+			return slack.Channel{}, err
+		}
+		event.Value = value.String
+		event.User = user.String
+		channel.History = append(channel.History, event)
+	}
+	return channel, rows.Err()
+}
+
 // GetParentMessages gets the parent messages in a channel
 // (i.e. messages not replying in a thread)
-// during the specified time interval
-func (d *ViewerDBHandle) GetParentMessages(channel string, from, to time.Time) ([]slack.StoredMessage, error) {
-	fromSecs := float64(from.UnixNano()) / 1e9
-	toSecs := float64(to.UnixNano()) / 1e9
-	rows, err := d.getMessages.Query(channel, fromSecs, toSecs)
+// during the specified time interval, leaving out the hidden subtypes.
+// Parents from before the interval are included if they have replies in it
+func (d *ViewerDBHandle) GetParentMessages(
+	channel string, from, to time.Time, hiddenSubtypes []string,
+) ([]slack.StoredMessage, error) {
+	query := `
+		SELECT ` + messageColumns("m") + ` FROM messages m
+			WHERE m.channel = ?1 AND m.top_level = true AND m.parent = "" AND (
+				m.ts_micros >= ?2 AND m.ts_micros < ?3 OR m.timestamp IN (
+					SELECT parent FROM messages
+						WHERE channel = ?1 AND parent != "" AND ts_micros >= ?2 AND ts_micros < ?3
+				)
+			)
+	`
+	args := []interface{}{channel, from.UnixNano() / 1e3, to.UnixNano() / 1e3}
+	if len(hiddenSubtypes) > 0 {
+		query += " AND m.subtype NOT IN (" + placeholders(len(hiddenSubtypes)) + ")"
+		for _, subtype := range hiddenSubtypes {
+			args = append(args, subtype)
+		}
+	}
+	rows, err := d.db.Query(query+" ORDER BY m.ts_micros;", args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	messages := make([]slack.StoredMessage, 0, 64)
 	for rows.Next() {
-		var msg slack.StoredMessage
-		var attachJSON, reactsJSON []byte
-		if err = rows.Scan(
-			&msg.Timestamp, &msg.Text, &msg.User, &attachJSON, &reactsJSON,
-		); err != nil {
-			return nil, err
-		}
-		if err = json.Unmarshal(attachJSON, &msg.Attachments); err != nil {
-			return nil, err
-		}
-		if err = json.Unmarshal(reactsJSON, &msg.Reacts); err != nil {
+		msg, err := scanMessage(rows)
+		if err != nil {
 			return nil, err
 		}
 		messages = append(messages, msg)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = d.addReactions(messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
 
-// GetThreadReplies gets the replies to the specified message
-func (d *ViewerDBHandle) GetThreadReplies(channel string, parentTimestamp string) ([]slack.ThreadMessage, error) {
-	rows, err := d.getReplies.Query(channel, parentTimestamp)
+// GetOrphanedParents gets the timestamps of thread parents that are
+// missing from the archive but have replies in the specified time interval
+func (d *ViewerDBHandle) GetOrphanedParents(channel string, from, to time.Time) ([]string, error) {
+	rows, err := d.getOrphans.Query(channel, from.UnixNano()/1e3, to.UnixNano()/1e3)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := make([]string, 0, 4)
+	for rows.Next() {
+		var parent string
+		if err = rows.Scan(&parent); err != nil {
+			return nil, err
+		}
+		parents = append(parents, parent)
+	}
+	return parents, rows.Err()
+}
+
+// GetThreads gets the threads in a channel, most recently active first.
+// Reply counts and participants combine the export's thread metadata
+// with the replies actually in the archive
+func (d *ViewerDBHandle) GetThreads(channel string, limit, offset int) ([]slack.StoredThread, error) {
+	rows, err := d.getThreads.Query(channel, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	replies := make([]slack.ThreadMessage, 0, 4)
+	threads := make([]slack.StoredThread, 0, limit)
+	timestamps := make([]string, 0, limit)
 	for rows.Next() {
-		var msg slack.ThreadMessage
-		var attachJSON, reactsJSON []byte
-		var timestampString string
+		var thread slack.StoredThread
+		var archivedCount int
+		var archivedLatest int64
+		var replyCount, latestReply sql.NullInt64
+		var replyUsersJSON []byte
 		if err = rows.Scan(
-			&timestampString, &msg.Text, &msg.User, &attachJSON, &reactsJSON, &msg.SentToChannel,
+			&thread.Timestamp, &archivedCount, &archivedLatest, &replyCount, &replyUsersJSON, &latestReply,
 		); err != nil {
 			return nil, err
 		}
-		splitTimestamp := strings.Split(timestampString, ".")
-		timestamp, err := strconv.ParseUint(splitTimestamp[0], 10, 64)
+		thread.ReplyCount = archivedCount
+		if int(replyCount.Int64) > archivedCount {
+			thread.ReplyCount = int(replyCount.Int64)
+		}
+		thread.LatestReply = archivedLatest
+		if latestReply.Int64 > archivedLatest {
+			thread.LatestReply = latestReply.Int64
+		}
+		if replyUsersJSON != nil {
+			if err = json.Unmarshal(replyUsersJSON, &thread.Participants); err != nil {
+				return nil, err
+			}
+		}
+		threads = append(threads, thread)
+		timestamps = append(timestamps, thread.Timestamp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	parents, err := d.loadMessages(channel, timestamps)
+	if err != nil {
+		return nil, err
+	}
+	replyUsers, err := d.getReplyUsers(channel, timestamps)
+	if err != nil {
+		return nil, err
+	}
+	for i := range threads {
+		if parent, ok := parents[threads[i].Timestamp]; ok {
+			threads[i].Parent = &parent
+		}
+		threads[i].Participants = mergeUsers(threads[i].Participants, replyUsers[threads[i].Timestamp])
+	}
+	return threads, nil
+}
+
+// getReplyUsers gets the users who replied to each of the specified
+// messages in a channel in the order they first replied, keyed by the
+// parent's timestamp
+func (d *ViewerDBHandle) getReplyUsers(channel string, parentTimestamps []string) (map[string][]string, error) {
+	users := make(map[string][]string, len(parentTimestamps))
+	for _, group := range chunks(parentTimestamps) {
+		args := []interface{}{channel}
+		for _, ts := range group {
+			args = append(args, ts)
+		}
+		rows, err := d.db.Query(`
+			SELECT parent, user FROM messages
+				WHERE channel = ? AND parent IN (`+placeholders(len(group))+`)
+				GROUP BY parent, user ORDER BY parent, MIN(ts_micros);
+		`, args...)
 		if err != nil {
 			return nil, err
 		}
-		msg.Timestamp = timestamp
-		if err = json.Unmarshal(attachJSON, &msg.Attachments); err != nil {
+		for rows.Next() {
+			var parent, user string
+			if err = rows.Scan(&parent, &user); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			users[parent] = append(users[parent], user)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
 			return nil, err
 		}
-		if err = json.Unmarshal(reactsJSON, &msg.Reacts); err != nil {
+	}
+	return users, nil
+}
+
+// mergeUsers adds the users who replied to a thread in the archive
+// to the thread's participants from the export
+func mergeUsers(participants, replyUsers []string) []string {
+	seen := make(map[string]bool, len(participants))
+	for _, user := range participants {
+		seen[user] = true
+	}
+	for _, user := range replyUsers {
+		if !seen[user] {
+			seen[user] = true
+			participants = append(participants, user)
+		}
+	}
+	return participants
+}
+
+// GetThreadReplies gets the replies to each of the specified messages in a channel,
+// keyed by the parent's timestamp. Messages without replies are left out
+func (d *ViewerDBHandle) GetThreadReplies(
+	channel string, parentTimestamps []string,
+) (map[string][]slack.ThreadMessage, error) {
+	stored := make([]slack.StoredMessage, 0, len(parentTimestamps))
+	for _, group := range chunks(parentTimestamps) {
+		args := []interface{}{channel}
+		for _, ts := range group {
+			args = append(args, ts)
+		}
+		rows, err := d.db.Query(`
+			SELECT `+messageColumns("m")+` FROM messages m
+				WHERE m.channel = ? AND m.parent IN (`+placeholders(len(group))+`)
+				ORDER BY m.parent, m.ts_micros;
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			msg, err := scanMessage(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			stored = append(stored, msg)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
 			return nil, err
 		}
-		replies = append(replies, msg)
 	}
-	if len(replies) == 0 {
-		return nil, nil
+	if err := d.addReactions(stored); err != nil {
+		return nil, err
+	}
+	replies := make(map[string][]slack.ThreadMessage)
+	for _, msg := range stored {
+		reply, err := slack.ThreadMessageFromStored(msg)
+		if err != nil {
+			return nil, err
+		}
+		replies[msg.ParentTimestamp] = append(replies[msg.ParentTimestamp], reply)
 	}
 	return replies, nil
 }
+
+// GetReplyCounts counts the replies in the archive to each of the specified
+// messages in a channel, keyed by the parent's timestamp.
+// Messages without replies are left out
+func (d *ViewerDBHandle) GetReplyCounts(channel string, parentTimestamps []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, group := range chunks(parentTimestamps) {
+		args := []interface{}{channel}
+		for _, ts := range group {
+			args = append(args, ts)
+		}
+		rows, err := d.db.Query(`
+			SELECT parent, COUNT(*) FROM messages
+				WHERE channel = ? AND parent IN (`+placeholders(len(group))+`)
+				GROUP BY parent;
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var parent string
+			var count int
+			if err = rows.Scan(&parent, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts[parent] = count
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// GetPins gets the pinned messages in a channel, most recently pinned first
+func (d *ViewerDBHandle) GetPins(channel string) ([]slack.Pin, error) {
+	rows, err := d.getPins.Query(channel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pins := make([]slack.Pin, 0, 8)
+	timestamps := make([]string, 0, 8)
+	for rows.Next() {
+		var pin slack.Pin
+		if err = rows.Scan(&pin.Timestamp, &pin.PinnedBy, &pin.PinnedAt); err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+		timestamps = append(timestamps, pin.Timestamp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	messages, err := d.loadMessages(channel, timestamps)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pins {
+		if msg, ok := messages[pins[i].Timestamp]; ok {
+			pins[i].Message = &msg
+		}
+	}
+	return pins, nil
+}
+
+// GetBookmarks gets the links bookmarked in a channel
+func (d *ViewerDBHandle) GetBookmarks(channel string) ([]slack.Bookmark, error) {
+	rows, err := d.getBookmarks.Query(channel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bookmarks := make([]slack.Bookmark, 0, 8)
+	for rows.Next() {
+		var b slack.Bookmark
+		var title, emoji, creator sql.NullString
+		var created sql.NullInt64
+		if err = rows.Scan(&b.ID, &title, &b.Link, &emoji, &created, &creator); err != nil {
+			return nil, err
+		}
+		b.Title = title.String
+		b.Emoji = emoji.String
+		b.Created = created.Int64
+		b.Creator = creator.String
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
+// GetReactedMessages gets the messages from any channel that a user
+// reacted to with an emoji, in any skin tone, most recent first.
+// An empty channel matches every channel
+func (d *ViewerDBHandle) GetReactedMessages(userID, emoji, channel string, limit int) ([]slack.StoredMessage, error) {
+	rows, err := d.getReacted.Query(userID, slack.BaseEmoji(emoji), channel, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := make([]slack.StoredMessage, 0, limit)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = d.addReactions(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}